@@ -0,0 +1,261 @@
+// Package config is the configuration the example servers share: where they listen and how gnet runs them.
+//
+// Settings are flags, which can also be given in a JSON or YAML file named by --config or $GNET_CONFIG, and in
+// environment variables named after them, e.g. GNET_TCP_KEEPALIVE for --tcp_keepalive. Flags on the command line
+// override the environment, which overrides the file. This holds for the flags of a server beyond those of Server.
+package config
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/panjf2000/gnet/v2"
+)
+
+// envPrefix starts the names of the environment variables holding settings.
+const envPrefix = "GNET_"
+
+// Load balancing strategies, how gnet assigns new connections to event-loops.
+const (
+	RoundRobin       = "round_robin"
+	LeastConnections = "least_connections"
+	SourceAddrHash   = "source_addr_hash"
+)
+
+// Server is the configuration of a gnet server.
+type Server struct {
+	// Listen holds the URLs the server listens on, tcp://, tcp4://, tcp6://, udp://, udp4://, udp6://
+	// or unix://, e.g. tcp://:9000, tcp://[::1]:9000 or unix:///tmp/server.sock.
+	Listen []string
+
+	Multicore     bool
+	NumEventLoop  int
+	LoadBalancing string
+	ReusePort     bool
+
+	// ReadBufferCap and WriteBufferCap size the buffers of connections, SocketRecvBuffer and SocketSendBuffer
+	// those of the kernel, 0 leaving the defaults.
+	ReadBufferCap    int
+	WriteBufferCap   int
+	SocketRecvBuffer int
+	SocketSendBuffer int
+
+	// TCPKeepAlive is the interval of TCP keep-alive probes, 0 disables them.
+	TCPKeepAlive time.Duration
+
+	file string
+}
+
+// listenURLs is the repeatable --listen flag.
+type listenURLs struct {
+	urls *[]string
+}
+
+func (l listenURLs) String() string {
+	if l.urls == nil {
+		return ""
+	}
+	return strings.Join(*l.urls, ",")
+}
+
+func (l listenURLs) Set(url string) error {
+	if err := checkURL(url); err != nil {
+		return err
+	}
+	*l.urls = append(*l.urls, url)
+	return nil
+}
+
+func checkURL(url string) error {
+	i := strings.Index(url, "://")
+	if i < 0 {
+		return fmt.Errorf("missing scheme in %q, expect tcp://, udp:// or unix://", url)
+	}
+	switch url[:i] {
+	case "tcp", "tcp4", "tcp6", "udp", "udp4", "udp6", "unix":
+	default:
+		return fmt.Errorf("unsupported scheme in %q, expect tcp://, udp:// or unix://", url)
+	}
+	if url[i+3:] == "" {
+		return fmt.Errorf("missing address in %q", url)
+	}
+	return nil
+}
+
+// Flags registers the settings of c as flags of fs, the values c holds being the defaults.
+func (c *Server) Flags(fs *flag.FlagSet) {
+	fs.StringVar(&c.file, "config", "", "JSON or YAML file of settings, named after the flags, $GNET_CONFIG by default")
+	fs.Var(listenURLs{&c.Listen}, "listen", "URL to listen on, repeatable: tcp://:9000, tcp://[::1]:9000, udp://:9000, unix:///tmp/server.sock")
+	fs.BoolVar(&c.Multicore, "multicore", c.Multicore, "run an event-loop per CPU")
+	fs.IntVar(&c.NumEventLoop, "num_event_loop", c.NumEventLoop, "number of event-loops, 0 lets multicore decide")
+	fs.StringVar(&c.LoadBalancing, "load_balancing", c.LoadBalancing, "how connections are spread over event-loops: round_robin, least_connections or source_addr_hash")
+	fs.BoolVar(&c.ReusePort, "reuseport", c.ReusePort, "set SO_REUSEPORT on the listeners")
+	fs.IntVar(&c.ReadBufferCap, "read_buffer_cap", c.ReadBufferCap, "max bytes read from a connection at once, 0 for the default of 64KB")
+	fs.IntVar(&c.WriteBufferCap, "write_buffer_cap", c.WriteBufferCap, "size in bytes of the outbound buffers of connections, 0 for the default of 64KB")
+	fs.IntVar(&c.SocketRecvBuffer, "socket_recv_buffer", c.SocketRecvBuffer, "SO_RCVBUF of connections in bytes, 0 leaves the kernel default")
+	fs.IntVar(&c.SocketSendBuffer, "socket_send_buffer", c.SocketSendBuffer, "SO_SNDBUF of connections in bytes, 0 leaves the kernel default")
+	fs.DurationVar(&c.TCPKeepAlive, "tcp_keepalive", c.TCPKeepAlive, "interval of TCP keep-alive probes, 0 disables them")
+}
+
+// Load completes the flags of fs that haven't been set on the command line with the settings file and
+// the environment, it is to be called once fs has been parsed.
+func (c *Server) Load(fs *flag.FlagSet) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	settings := make(map[string][]string)
+	origin := make(map[string]string) // where each setting comes from, for errors
+	path := c.file
+	if path == "" {
+		path = os.Getenv(envPrefix + "CONFIG")
+	}
+	if path != "" {
+		var err error
+		if settings, err = readFile(path); err != nil {
+			return err
+		}
+		for name := range settings {
+			if fs.Lookup(name) == nil || name == "config" {
+				return fmt.Errorf("%s: unknown setting %q", path, name)
+			}
+			origin[name] = path
+		}
+	}
+	fs.VisitAll(func(f *flag.Flag) {
+		env := envPrefix + strings.ToUpper(f.Name)
+		if v, ok := os.LookupEnv(env); ok && f.Name != "config" {
+			origin[f.Name] = env
+			if f.Name == "listen" {
+				settings[f.Name] = strings.Split(v, ",")
+			} else {
+				settings[f.Name] = []string{v}
+			}
+		}
+	})
+	for name, values := range settings {
+		if set[name] {
+			continue
+		}
+		for _, v := range values {
+			if err := fs.Set(name, strings.TrimSpace(v)); err != nil {
+				return fmt.Errorf("%s: invalid value %q for %s: %v", origin[name], v, name, err)
+			}
+		}
+	}
+	return c.check()
+}
+
+func (c *Server) check() error {
+	switch c.LoadBalancing {
+	case "", RoundRobin, LeastConnections, SourceAddrHash:
+	default:
+		return fmt.Errorf("unknown load balancing strategy %q, expect round_robin, least_connections or source_addr_hash", c.LoadBalancing)
+	}
+	return nil
+}
+
+// Only returns an error if some listen URL has a scheme other than those of schemes, e.g. "udp" for
+// a server that only speaks TCP. Schemes cover their variants, "tcp" covers "tcp4" and "tcp6".
+func (c *Server) Only(schemes ...string) error {
+	for _, url := range c.Listen {
+		scheme := strings.TrimRight(url[:strings.Index(url, "://")], "46")
+		ok := false
+		for _, s := range schemes {
+			ok = ok || s == scheme
+		}
+		if !ok {
+			return fmt.Errorf("%s isn't supported, expect %s", url, strings.Join(schemes, ", "))
+		}
+	}
+	return nil
+}
+
+// Options returns the gnet options of c, followed by opts.
+func (c *Server) Options(opts ...gnet.Option) []gnet.Option {
+	options := []gnet.Option{
+		gnet.WithMulticore(c.Multicore),
+		gnet.WithNumEventLoop(c.NumEventLoop),
+		gnet.WithReusePort(c.ReusePort),
+		gnet.WithReadBufferCap(c.ReadBufferCap),
+		gnet.WithWriteBufferCap(c.WriteBufferCap),
+		gnet.WithSocketRecvBuffer(c.SocketRecvBuffer),
+		gnet.WithSocketSendBuffer(c.SocketSendBuffer),
+		gnet.WithTCPKeepAlive(c.TCPKeepAlive),
+	}
+	switch c.LoadBalancing {
+	case LeastConnections:
+		options = append(options, gnet.WithLoadBalancing(gnet.LeastConnections))
+	case SourceAddrHash:
+		options = append(options, gnet.WithLoadBalancing(gnet.SourceAddrHash))
+	default:
+		options = append(options, gnet.WithLoadBalancing(gnet.RoundRobin))
+	}
+	return append(options, opts...)
+}
+
+// Run serves every listen URL of c with an engine of its own, and the event handler newHandler returns
+// for the URL: the engines call OnBoot and OnTick concurrently, so they don't share a handler.
+// Once an engine stops, the others are stopped too, and Run returns the error of the first one when they all have.
+// The socket file left over by a previous run at a unix:// URL is removed first.
+func (c *Server) Run(newHandler func(url string) gnet.EventHandler, opts ...gnet.Option) error {
+	if len(c.Listen) == 0 {
+		return errors.New("no URL to listen on")
+	}
+	engines := make([]*engine, len(c.Listen))
+	stopped := make(chan *engine, len(c.Listen))
+	for i, url := range c.Listen {
+		RemoveStaleSocket(url)
+		e := &engine{url: url, done: make(chan struct{})}
+		engines[i] = e
+		eh := newHandler(url)
+		go func() {
+			e.err = gnet.Run(eh, e.url, c.Options(opts...)...)
+			close(e.done)
+			stopped <- e
+		}()
+	}
+	first := <-stopped
+	for _, e := range engines {
+		e.stop()
+	}
+	return first.err
+}
+
+// engine is a gnet engine started by Run, done is closed once it has exited with err.
+type engine struct {
+	url  string
+	done chan struct{}
+	err  error
+}
+
+// stop keeps asking gnet to stop the engine until it exits, an engine that is still booting
+// isn't registered yet and gnet.Stop reports it as already in shutdown.
+func (e *engine) stop() {
+	for {
+		if err := gnet.Stop(context.Background(), e.url); err == nil {
+			<-e.done
+			return
+		}
+		select {
+		case <-e.done:
+			return
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// RemoveStaleSocket removes the socket file at a unix:// URL, for the server to be able to listen there again.
+func RemoveStaleSocket(url string) {
+	path := strings.TrimPrefix(url, "unix://")
+	if path == url {
+		return
+	}
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		_ = os.Remove(path)
+	}
+}
@@ -0,0 +1,163 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// readFile reads a settings file, JSON if its name ends in .json and YAML otherwise, into the values of
+// the settings it holds, several for lists.
+func readFile(path string) (map[string][]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var settings map[string][]string
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		settings, err = parseJSON(data)
+	} else {
+		settings, err = parseYAML(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return settings, nil
+}
+
+// parseJSON parses an object of settings whose values are strings, numbers, booleans or arrays of those.
+func parseJSON(data []byte) (map[string][]string, error) {
+	var obj map[string]interface{}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, err
+	}
+	settings := make(map[string][]string, len(obj))
+	for name, v := range obj {
+		elems, ok := v.([]interface{})
+		if !ok {
+			elems = []interface{}{v}
+		}
+		for _, e := range elems {
+			var s string
+			switch e := e.(type) {
+			case string:
+				s = e
+			case bool:
+				s = strconv.FormatBool(e)
+			case float64:
+				s = strconv.FormatFloat(e, 'f', -1, 64)
+			default:
+				return nil, fmt.Errorf("unsupported value for %q", name)
+			}
+			settings[name] = append(settings[name], s)
+		}
+	}
+	return settings, nil
+}
+
+// parseYAML parses the subset of YAML settings need: a mapping of names to scalars, or to lists, either
+// in flow style or as block sequences, with comments.
+//
+//	listen:
+//	  - tcp://:9080
+//	  - unix:///tmp/http.sock
+//	multicore: true # one event-loop per CPU
+//	tcp_keepalive: 30s
+func parseYAML(data []byte) (map[string][]string, error) {
+	settings := make(map[string][]string)
+	var list string // the setting whose block sequence is being read
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		line := stripComment(sc.Text())
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed == "---" {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "- ") || trimmed == "-" {
+			if list == "" || line[0] != ' ' && line[0] != '-' {
+				return nil, fmt.Errorf("line %d: list item outside of a list", n)
+			}
+			v, err := unquote(strings.TrimSpace(trimmed[1:]))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", n, err)
+			}
+			settings[list] = append(settings[list], v)
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			return nil, fmt.Errorf("line %d: nested mappings aren't supported", n)
+		}
+		i := strings.IndexByte(line, ':')
+		if i <= 0 {
+			return nil, fmt.Errorf("line %d: expect name: value", n)
+		}
+		name, value := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		if _, dup := settings[name]; dup {
+			return nil, fmt.Errorf("line %d: %s set twice", n, name)
+		}
+		list = ""
+		switch {
+		case value == "":
+			list, settings[name] = name, nil
+		case value[0] == '[':
+			if value[len(value)-1] != ']' {
+				return nil, fmt.Errorf("line %d: unterminated list", n)
+			}
+			settings[name] = []string{}
+			for _, e := range strings.Split(value[1:len(value)-1], ",") {
+				if e = strings.TrimSpace(e); e == "" {
+					continue
+				}
+				v, err := unquote(e)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %v", n, err)
+				}
+				settings[name] = append(settings[name], v)
+			}
+		default:
+			v, err := unquote(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", n, err)
+			}
+			settings[name] = []string{v}
+		}
+	}
+	return settings, sc.Err()
+}
+
+// stripComment cuts line at a # starting a comment, that is one at the start of the line or after a blank
+// and outside of quotes.
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func unquote(s string) (string, error) {
+	if len(s) == 0 || s[0] != '"' && s[0] != '\'' {
+		return s, nil
+	}
+	if len(s) < 2 || s[len(s)-1] != s[0] {
+		return "", fmt.Errorf("unterminated string %s", s)
+	}
+	if s[0] == '\'' {
+		return strings.Replace(s[1:len(s)-1], "''", "'", -1), nil
+	}
+	return strconv.Unquote(s)
+}
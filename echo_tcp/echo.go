@@ -6,6 +6,8 @@ import (
 	"log"
 
 	"github.com/panjf2000/gnet/v2"
+
+	"github.com/gnet-io/gnet-examples/config"
 )
 
 type echoServer struct {
@@ -30,12 +32,23 @@ func (es *echoServer) OnTraffic(c gnet.Conn) gnet.Action {
 
 func main() {
 	var port int
-	var multicore bool
+	var cfg config.Server
 
 	// Example command: go run echo.go --port 9000 --multicore=true
-	flag.IntVar(&port, "port", 9000, "--port 9000")
-	flag.BoolVar(&multicore, "multicore", false, "--multicore true")
+	// or: go run echo.go --listen tcp://:9000 --listen tcp://[::1]:9000 --tcp_keepalive 30s
+	cfg.Flags(flag.CommandLine)
+	flag.IntVar(&port, "port", 9000, "--port 9000, used when no --listen is given")
 	flag.Parse()
-	echo := &echoServer{addr: fmt.Sprintf("tcp://:%d", port), multicore: multicore}
-	log.Fatal(gnet.Run(echo, echo.addr, gnet.WithMulticore(multicore)))
+	if err := cfg.Load(flag.CommandLine); err != nil {
+		log.Fatal(err)
+	}
+	if len(cfg.Listen) == 0 {
+		cfg.Listen = []string{fmt.Sprintf("tcp://:%d", port)}
+	}
+	if err := cfg.Only("tcp", "unix"); err != nil {
+		log.Fatal(err)
+	}
+	log.Fatal(cfg.Run(func(url string) gnet.EventHandler {
+		return &echoServer{addr: url, multicore: cfg.Multicore}
+	}))
 }
@@ -6,6 +6,8 @@ import (
 	"log"
 
 	"github.com/panjf2000/gnet/v2"
+
+	"github.com/gnet-io/gnet-examples/config"
 )
 
 type echoServer struct {
@@ -20,13 +22,23 @@ func (es *echoServer) OnTraffic(c gnet.Conn) gnet.Action {
 
 func main() {
 	var port int
-	var multicore, reuseport bool
+	var cfg config.Server
 
 	// Example command: go run echo.go --port 9000 --multicore=true --reuseport=true
-	flag.IntVar(&port, "port", 9000, "--port 9000")
-	flag.BoolVar(&multicore, "multicore", false, "--multicore true")
-	flag.BoolVar(&reuseport, "reuseport", false, "--reuseport true")
+	// or: go run echo.go --listen udp://:9000 --listen udp6://[::1]:9000 --socket_recv_buffer 4194304
+	cfg.Flags(flag.CommandLine)
+	flag.IntVar(&port, "port", 9000, "--port 9000, used when no --listen is given")
 	flag.Parse()
-	echo := new(echoServer)
-	log.Fatal(gnet.Run(echo, fmt.Sprintf("udp://:%d", port), gnet.WithMulticore(multicore), gnet.WithReusePort(reuseport)))
+	if err := cfg.Load(flag.CommandLine); err != nil {
+		log.Fatal(err)
+	}
+	if len(cfg.Listen) == 0 {
+		cfg.Listen = []string{fmt.Sprintf("udp://:%d", port)}
+	}
+	if err := cfg.Only("udp"); err != nil {
+		log.Fatal(err)
+	}
+	log.Fatal(cfg.Run(func(string) gnet.EventHandler {
+		return new(echoServer)
+	}))
 }
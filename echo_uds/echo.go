@@ -6,6 +6,8 @@ import (
 	"log"
 
 	"github.com/panjf2000/gnet/v2"
+
+	"github.com/gnet-io/gnet-examples/config"
 )
 
 type echoServer struct {
@@ -20,13 +22,24 @@ func (es *echoServer) OnTraffic(c gnet.Conn) gnet.Action {
 
 func main() {
 	var addr string
-	var multicore bool
+	var cfg config.Server
 
 	// Example command: go run echo.go --sock echo.sock --multicore=true
-	flag.StringVar(&addr, "sock", "echo.sock", "--port 9000")
-	flag.BoolVar(&multicore, "multicore", false, "--multicore true")
+	// or: go run echo.go --listen unix:///tmp/echo.sock --write_buffer_cap 16384
+	cfg.Flags(flag.CommandLine)
+	flag.StringVar(&addr, "sock", "echo.sock", "--sock echo.sock, used when no --listen is given")
 	flag.Parse()
+	if err := cfg.Load(flag.CommandLine); err != nil {
+		log.Fatal(err)
+	}
+	if len(cfg.Listen) == 0 {
+		cfg.Listen = []string{fmt.Sprintf("unix://%s", addr)}
+	}
+	if err := cfg.Only("unix"); err != nil {
+		log.Fatal(err)
+	}
 
-	echo := new(echoServer)
-	log.Fatal(gnet.Run(echo, fmt.Sprintf("unix://%s", addr), gnet.WithMulticore(multicore)))
+	log.Fatal(cfg.Run(func(string) gnet.EventHandler {
+		return new(echoServer)
+	}))
 }
@@ -8,4 +8,5 @@ require (
 	github.com/gorilla/websocket v1.4.2
 	github.com/panjf2000/gnet/v2 v2.0.0
 	github.com/vektra/errors v0.0.0-20140903201135-c64d83aba85a // indirect
+	golang.org/x/net v0.7.0
 )
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/panjf2000/gnet/v2"
+)
+
+// Access log formats.
+const (
+	logFormatCommon   = "common"
+	logFormatCombined = "combined"
+	logFormatJSON     = "json"
+)
+
+// accessLogger writes access log lines from a goroutine of its own: event-loops format a line
+// and queue it, they never wait on the disk. Lines are dropped when the queue is full.
+type accessLogger struct {
+	format     string
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	lines   chan []byte
+	done    chan struct{}
+	once    sync.Once
+	dropped int64
+
+	// Only accessed by the writing goroutine.
+	f    *os.File
+	w    *bufio.Writer
+	size int64
+}
+
+// newAccessLogger opens the access log at path, "-" being the standard output. The file is rotated once it
+// reaches maxSize bytes, 0 disables rotation, and the maxBackups most recent ones are kept as path.1, path.2...
+func newAccessLogger(path, format string, maxSize int64, maxBackups int) (*accessLogger, error) {
+	switch format {
+	case logFormatCommon, logFormatCombined, logFormatJSON:
+	default:
+		return nil, fmt.Errorf("unknown access log format %q, expect common, combined or json", format)
+	}
+	l := &accessLogger{
+		format:     format,
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+		lines:      make(chan []byte, 8192),
+		done:       make(chan struct{}),
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	go l.run()
+	return l, nil
+}
+
+func (l *accessLogger) open() error {
+	if l.path == "-" {
+		l.f, l.size = os.Stdout, 0
+	} else {
+		f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return err
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			_ = f.Close()
+			return err
+		}
+		l.f, l.size = f, fi.Size()
+	}
+	if l.w == nil {
+		l.w = bufio.NewWriterSize(l.f, 64<<10)
+	} else {
+		l.w.Reset(l.f)
+	}
+	return nil
+}
+
+// run writes the queued lines, it flushes whenever the queue runs dry, and at least once per second.
+func (l *accessLogger) run() {
+	defer close(l.done)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case line, ok := <-l.lines:
+			if !ok {
+				_ = l.w.Flush()
+				if l.f != os.Stdout {
+					_ = l.f.Close()
+				}
+				return
+			}
+			l.write(line)
+			if len(l.lines) == 0 {
+				_ = l.w.Flush()
+			}
+		case <-ticker.C:
+			_ = l.w.Flush()
+			if n := atomic.SwapInt64(&l.dropped, 0); n > 0 {
+				log.Printf("access log is lagging behind, %d lines dropped\n", n)
+			}
+		}
+	}
+}
+
+func (l *accessLogger) write(line []byte) {
+	if l.maxSize > 0 && l.f != os.Stdout && l.size+int64(len(line)) > l.maxSize && l.size > 0 {
+		if err := l.rotate(); err != nil {
+			log.Printf("failed to rotate access log %s: %v\n", l.path, err)
+		}
+	}
+	n, _ := l.w.Write(line)
+	l.size += int64(n)
+}
+
+// rotate shifts path.N-1 to path.N, ..., path to path.1, and starts a new file at path.
+func (l *accessLogger) rotate() error {
+	_ = l.w.Flush()
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+	if l.maxBackups > 0 {
+		for i := l.maxBackups - 1; i > 0; i-- {
+			_ = os.Rename(l.path+"."+strconv.Itoa(i), l.path+"."+strconv.Itoa(i+1))
+		}
+		if err := os.Rename(l.path, l.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(l.path); err != nil {
+		return err
+	}
+	return l.open()
+}
+
+// close writes out the queued lines, the logger must not be used anymore.
+func (l *accessLogger) close() {
+	l.once.Do(func() {
+		close(l.lines)
+		<-l.done
+	})
+}
+
+// accessEntry is what's known about a request and its response once it has been served.
+type accessEntry struct {
+	start      time.Time
+	remoteAddr string
+	method     string
+	uri        string
+	proto      string
+	referer    string
+	userAgent  string
+	requestID  string
+	status     int
+	bytes      int64
+}
+
+func (l *accessLogger) log(e *accessEntry) {
+	var line []byte
+	switch l.format {
+	case logFormatJSON:
+		line = e.appendJSON(nil)
+	default:
+		line = e.appendCommon(nil, l.format == logFormatCombined)
+	}
+	select {
+	case l.lines <- line:
+	default:
+		atomic.AddInt64(&l.dropped, 1)
+	}
+}
+
+// appendCommon appends the entry in the Common Log Format, or in the Combined Log Format which adds
+// the Referer and User-Agent of the request. The ID of the request, if it has one, comes last.
+func (e *accessEntry) appendCommon(buf []byte, combined bool) []byte {
+	host := e.remoteAddr
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	buf = append(buf, host...)
+	buf = append(buf, " - - ["...)
+	buf = e.start.AppendFormat(buf, "02/Jan/2006:15:04:05 -0700")
+	buf = append(buf, "] "...)
+	buf = strconv.AppendQuote(buf, e.method+" "+e.uri+" "+e.proto)
+	buf = append(buf, ' ')
+	buf = strconv.AppendInt(buf, int64(e.status), 10)
+	buf = append(buf, ' ')
+	if e.bytes == 0 {
+		buf = append(buf, '-')
+	} else {
+		buf = strconv.AppendInt(buf, e.bytes, 10)
+	}
+	if combined {
+		buf = append(buf, ' ')
+		buf = appendQuoteOrDash(buf, e.referer)
+		buf = append(buf, ' ')
+		buf = appendQuoteOrDash(buf, e.userAgent)
+	}
+	if e.requestID != "" {
+		buf = append(buf, ' ')
+		buf = strconv.AppendQuote(buf, e.requestID)
+	}
+	return append(buf, '\n')
+}
+
+func appendQuoteOrDash(buf []byte, s string) []byte {
+	if s == "" {
+		return append(buf, `"-"`...)
+	}
+	return strconv.AppendQuote(buf, s)
+}
+
+func (e *accessEntry) appendJSON(buf []byte) []byte {
+	b, _ := json.Marshal(struct {
+		Time       string  `json:"time"`
+		RemoteAddr string  `json:"remote_addr"`
+		Method     string  `json:"method"`
+		URI        string  `json:"uri"`
+		Proto      string  `json:"proto"`
+		Status     int     `json:"status"`
+		Bytes      int64   `json:"bytes"`
+		LatencyMs  float64 `json:"latency_ms"`
+		Referer    string  `json:"referer,omitempty"`
+		UserAgent  string  `json:"user_agent,omitempty"`
+		RequestID  string  `json:"request_id,omitempty"`
+	}{
+		Time:       e.start.Format(time.RFC3339Nano),
+		RemoteAddr: e.remoteAddr,
+		Method:     e.method,
+		URI:        e.uri,
+		Proto:      e.proto,
+		Status:     e.status,
+		Bytes:      e.bytes,
+		LatencyMs:  float64(time.Since(e.start)) / float64(time.Millisecond),
+		Referer:    e.referer,
+		UserAgent:  e.userAgent,
+		RequestID:  e.requestID,
+	})
+	buf = append(buf, b...)
+	return append(buf, '\n')
+}
+
+// accessLog returns a middleware that logs every request to l once its response is complete,
+// the logged size is that of the body as sent, after any compression by inner middlewares.
+func accessLog(l *accessLogger) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(w ResponseWriter, r *Request) {
+			lw := &loggingWriter{w: w, l: l, entry: accessEntry{
+				start:      time.Now(),
+				remoteAddr: r.RemoteAddr,
+				method:     r.Method,
+				uri:        r.RequestURI,
+				proto:      r.Proto,
+				referer:    r.Header.Get("Referer"),
+				userAgent:  r.Header.Get("User-Agent"),
+				requestID:  r.Header.Get("X-Request-Id"),
+			}}
+			next.ServeHTTP(lw, r)
+			if !lw.detached {
+				lw.log()
+			}
+		})
+	}
+}
+
+// loggingWriter records the status and body size of a response.
+type loggingWriter struct {
+	w     ResponseWriter
+	l     *accessLogger
+	entry accessEntry
+
+	// detached is set once the response has been handed over, to the loggingWriter returned by detachWriter.
+	detached bool
+}
+
+func (lw *loggingWriter) Header() http.Header {
+	return lw.w.Header()
+}
+
+func (lw *loggingWriter) WriteHeader(statusCode int) {
+	if lw.entry.status == 0 {
+		lw.entry.status = statusCode
+	}
+	lw.w.WriteHeader(statusCode)
+}
+
+func (lw *loggingWriter) Write(p []byte) (int, error) {
+	if lw.entry.status == 0 {
+		lw.entry.status = http.StatusOK
+	}
+	n, err := lw.w.Write(p)
+	lw.entry.bytes += int64(n)
+	return n, err
+}
+
+func (lw *loggingWriter) Flush() {
+	lw.w.Flush()
+}
+
+func (lw *loggingWriter) log() {
+	if lw.entry.status == 0 {
+		lw.entry.status = http.StatusOK
+	}
+	lw.l.log(&lw.entry)
+}
+
+// detachWriter hands the response over along with the logging, if the wrapped ResponseWriter can be.
+func (lw *loggingWriter) detachWriter() asyncWriter {
+	d, ok := lw.w.(detacher)
+	if !ok {
+		return nil
+	}
+	w := d.detachWriter()
+	if w == nil {
+		return nil
+	}
+	lw.detached = true
+	return &loggingWriter{w: w, l: lw.l, entry: lw.entry}
+}
+
+func (lw *loggingWriter) finish() {
+	lw.w.(asyncWriter).finish()
+	lw.log()
+}
+
+func (lw *loggingWriter) fail() {
+	lw.w.(asyncWriter).fail()
+	lw.entry.status = http.StatusInternalServerError
+	lw.log()
+}
+
+func (lw *loggingWriter) codec() *httpCodec {
+	return lw.w.(asyncWriter).codec()
+}
+
+func (lw *loggingWriter) backlog(c gnet.Conn) (int, error) {
+	return lw.w.(asyncWriter).backlog(c)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/panjf2000/gnet/v2/pkg/pool/goroutine"
+)
+
+// netHTTPHandler runs a net/http Handler on a worker pool, so that handlers which block,
+// on a database or a downstream service, don't stall the event-loop and every other connection on it.
+type netHTTPHandler struct {
+	handler http.Handler
+	pool    *goroutine.Pool
+}
+
+// adaptHandler makes h servable by the gnet HTTP server, http.ServeMux and third-party middleware included.
+func adaptHandler(h http.Handler, pool *goroutine.Pool) Handler {
+	return &netHTTPHandler{handler: h, pool: pool}
+}
+
+func (a *netHTTPHandler) ServeHTTP(w ResponseWriter, r *Request) {
+	req, err := toHTTPRequest(r)
+	if err != nil {
+		http.Error(w, "400 bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	// From here on the response is completed by a worker and written back with AsyncWrite,
+	// the asyncWriter satisfies http.ResponseWriter and http.Flusher.
+	var rw asyncWriter
+	if d, ok := w.(detacher); ok {
+		rw = d.detachWriter()
+	}
+	if rw == nil {
+		// A ResponseWriter wrapped by a middleware that can't hand it over, the handler blocks the event-loop.
+		a.handler.ServeHTTP(w, req)
+		return
+	}
+	err = a.pool.Submit(func() {
+		defer func() {
+			if p := recover(); p != nil {
+				if p != http.ErrAbortHandler {
+					log.Printf("panic serving %s %s: %v\n", req.Method, req.RequestURI, p)
+				}
+				rw.fail()
+				return
+			}
+			rw.finish()
+		}()
+		a.handler.ServeHTTP(rw, req)
+	})
+	if err != nil {
+		http.Error(rw, "503 service unavailable", http.StatusServiceUnavailable)
+		rw.finish()
+	}
+}
+
+// toHTTPRequest converts r into an *http.Request that outlives the event-loop callback:
+// the header and the body are copied, as the buffers r points into are reused for the next request.
+func toHTTPRequest(r *Request) (*http.Request, error) {
+	u, err := url.ParseRequestURI(r.RequestURI)
+	if err != nil {
+		return nil, err
+	}
+	major, minor, ok := http.ParseHTTPVersion(r.Proto)
+	if !ok {
+		major, minor = 1, 1
+	}
+	req := &http.Request{
+		Method:        r.Method,
+		URL:           u,
+		Proto:         r.Proto,
+		ProtoMajor:    major,
+		ProtoMinor:    minor,
+		Header:        r.Header.Clone(),
+		Body:          http.NoBody,
+		ContentLength: r.ContentLength,
+		Host:          r.Host,
+		RemoteAddr:    r.RemoteAddr,
+		RequestURI:    r.RequestURI,
+		Trailer:       r.Trailer.Clone(),
+		Close:         r.Close,
+	}
+	if len(r.Body) > 0 {
+		req.Body = ioutil.NopCloser(bytes.NewReader(append([]byte(nil), r.Body...)))
+	}
+	return req, nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Load balancing strategies of an upstream pool.
+const (
+	lbRoundRobin     = "round_robin"
+	lbLeastConns     = "least_conn"
+	lbConsistentHash = "consistent_hash"
+)
+
+const (
+	// maxIdleUpstreamConns is how many keep-alive connections are kept open to each upstream.
+	maxIdleUpstreamConns = 32
+	// hashReplicas is the number of points of each upstream on the hash ring, the more the evener the spread.
+	hashReplicas = 160
+)
+
+// upstream is a server of an upstream pool.
+type upstream struct {
+	addr string
+
+	// down is set while the upstream fails its health checks, active counts the requests in flight to it.
+	down   int32
+	active int64
+	// checked is set if the health of the upstream is checked, see watchHealth.
+	checked bool
+
+	mu   sync.Mutex
+	idle []*upstreamConn
+}
+
+func (u *upstream) healthy() bool {
+	return atomic.LoadInt32(&u.down) == 0
+}
+
+// setHealthy records the outcome of a health check, or of a failed attempt to connect, and logs the changes.
+// A failed attempt only counts if the health of the upstream is checked, as nothing would bring it back otherwise.
+func (u *upstream) setHealthy(ok bool, reason string) {
+	var down int32
+	if !ok {
+		down = 1
+	}
+	if atomic.SwapInt32(&u.down, down) != down {
+		if ok {
+			log.Printf("upstream %s is up\n", u.addr)
+		} else {
+			log.Printf("upstream %s is down: %s\n", u.addr, reason)
+		}
+	}
+}
+
+// getIdle takes a keep-alive connection to the upstream, if there is one.
+func (u *upstream) getIdle() *upstreamConn {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	n := len(u.idle)
+	if n == 0 {
+		return nil
+	}
+	uc := u.idle[n-1]
+	u.idle = u.idle[:n-1]
+	return uc
+}
+
+// putIdle keeps uc for the next request, it reports false if there are enough idle connections already.
+func (u *upstream) putIdle(uc *upstreamConn) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if len(u.idle) >= maxIdleUpstreamConns {
+		return false
+	}
+	u.idle = append(u.idle, uc)
+	return true
+}
+
+func (u *upstream) removeIdle(uc *upstreamConn) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for i, c := range u.idle {
+		if c == uc {
+			u.idle = append(u.idle[:i], u.idle[i+1:]...)
+			return
+		}
+	}
+}
+
+// ringPoint is a point of an upstream on the hash ring.
+type ringPoint struct {
+	hash uint32
+	u    *upstream
+}
+
+// upstreamPool balances requests over a set of upstream servers, skipping the ones that are down.
+type upstreamPool struct {
+	strategy  string
+	upstreams []*upstream
+	next      uint64
+	ring      []ringPoint
+}
+
+func newUpstreamPool(addrs []string, strategy string) (*upstreamPool, error) {
+	switch strategy {
+	case lbRoundRobin, lbLeastConns, lbConsistentHash:
+	default:
+		return nil, fmt.Errorf("unknown load balancing strategy %q, expect round_robin, least_conn or consistent_hash", strategy)
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("an upstream pool needs at least one server")
+	}
+	p := &upstreamPool{strategy: strategy}
+	for _, addr := range addrs {
+		u := &upstream{addr: addr}
+		p.upstreams = append(p.upstreams, u)
+		for i := 0; i < hashReplicas; i++ {
+			p.ring = append(p.ring, ringPoint{hash32(addr + "#" + strconv.Itoa(i)), u})
+		}
+	}
+	sort.Slice(p.ring, func(i, j int) bool { return p.ring[i].hash < p.ring[j].hash })
+	return p, nil
+}
+
+// pick chooses the upstream of a request, key is what consistent hashing goes by. It returns nil
+// if all the upstreams are down.
+func (p *upstreamPool) pick(key string) *upstream {
+	n := len(p.upstreams)
+	switch p.strategy {
+	case lbLeastConns:
+		var best *upstream
+		for _, u := range p.upstreams {
+			if u.healthy() && (best == nil || atomic.LoadInt64(&u.active) < atomic.LoadInt64(&best.active)) {
+				best = u
+			}
+		}
+		return best
+	case lbConsistentHash:
+		// The keys of an upstream that is down move to the next points of the ring, the other keys stay put.
+		h := hash32(key)
+		i := sort.Search(len(p.ring), func(i int) bool { return p.ring[i].hash >= h })
+		for j := 0; j < len(p.ring); j++ {
+			if u := p.ring[(i+j)%len(p.ring)].u; u.healthy() {
+				return u
+			}
+		}
+		return nil
+	default:
+		start := atomic.AddUint64(&p.next, 1)
+		for i := 0; i < n; i++ {
+			if u := p.upstreams[(start+uint64(i))%uint64(n)]; u.healthy() {
+				return u
+			}
+		}
+		return nil
+	}
+}
+
+// watchHealth starts checking the health of the upstreams, see checkHealth, it must be called before
+// the pool is used.
+func (p *upstreamPool) watchHealth(path string, interval, timeout time.Duration) {
+	for _, u := range p.upstreams {
+		u.checked = true
+	}
+	go p.checkHealth(path, interval, timeout)
+}
+
+// checkHealth requests path from every upstream each interval, an upstream answering with a 5xx status,
+// or not at all within timeout, is taken out of the pool until it answers properly again.
+func (p *upstreamPool) checkHealth(path string, interval, timeout time.Duration) {
+	client := &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{DisableKeepAlives: true},
+	}
+	for {
+		for _, u := range p.upstreams {
+			resp, err := client.Get("http://" + u.addr + path)
+			switch {
+			case err != nil:
+				u.setHealthy(false, err.Error())
+			case resp.StatusCode >= http.StatusInternalServerError:
+				_ = resp.Body.Close()
+				u.setHealthy(false, resp.Status)
+			default:
+				_ = resp.Body.Close()
+				u.setHealthy(true, "")
+			}
+		}
+		time.Sleep(interval)
+	}
+}
+
+func hash32(s string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(s))
+	return h.Sum32()
+}
@@ -0,0 +1,127 @@
+// Command bench is a load generator comparing HTTP servers on the local machine, typically the gnet HTTP
+// server of the parent directory with its net/http equivalent in stdserver. It runs every server with each
+// pipeline depth in turn and prints the requests per second and the latency percentiles side by side.
+//
+// Start the servers, then the benchmark. The gnet server runs without the security headers and request IDs
+// it adds by default, which stdserver doesn't send:
+//
+//	go run ./http --port 9080 --secure_headers=false --request_id=false &
+//	go run ./http/bench/stdserver --port 9081 &
+//	go run ./http/bench --targets gnet=127.0.0.1:9080,net/http=127.0.0.1:9081 --conns 100 --pipeline 1,16
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// target is a server under test.
+type target struct {
+	name string
+	addr string
+}
+
+func parseTargets(s string) ([]target, error) {
+	var targets []target
+	for _, t := range strings.Split(s, ",") {
+		i := strings.IndexByte(t, '=')
+		if i <= 0 || i == len(t)-1 {
+			return nil, fmt.Errorf("invalid target %q, expect name=host:port", t)
+		}
+		targets = append(targets, target{name: t[:i], addr: t[i+1:]})
+	}
+	return targets, nil
+}
+
+func parseDepths(s string) ([]int, error) {
+	var depths []int
+	for _, d := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(d))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid pipeline depth %q", d)
+		}
+		depths = append(depths, n)
+	}
+	return depths, nil
+}
+
+func main() {
+	var targetList, path, pipeline string
+	var conns int
+	var warmup, duration, timeout, cooldown time.Duration
+
+	// Example command: go run . --targets gnet=127.0.0.1:9080,net/http=127.0.0.1:9081 --conns 100 --pipeline 1,16 --duration 10s
+	flag.StringVar(&targetList, "targets", "gnet=127.0.0.1:9080,net/http=127.0.0.1:9081", "servers to compare, as name=host:port,name=host:port")
+	flag.StringVar(&path, "path", "/", "path requested from the servers")
+	flag.IntVar(&conns, "conns", 50, "number of concurrent connections")
+	flag.StringVar(&pipeline, "pipeline", "1,16", "pipeline depths to run, 1 being plain keep-alive requests")
+	flag.DurationVar(&warmup, "warmup", 2*time.Second, "load before measuring, to warm up the servers")
+	flag.DurationVar(&duration, "duration", 10*time.Second, "duration of the measurement of each run")
+	flag.DurationVar(&timeout, "timeout", 5*time.Second, "max time for a batch of requests to be answered")
+	flag.DurationVar(&cooldown, "cooldown", time.Second, "pause between runs")
+	flag.Parse()
+
+	targets, err := parseTargets(targetList)
+	if err != nil {
+		log.Fatal(err)
+	}
+	depths, err := parseDepths(pipeline)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Printf("%d connections, %v per run after %v of warmup, GET %s, GOMAXPROCS %d on %d CPUs\n\n",
+		conns, duration, warmup, path, runtime.GOMAXPROCS(0), runtime.NumCPU())
+	_, _ = fmt.Fprintln(tw, "server\tpipeline\trequests\treq/s\trelative\tp50\tp90\tp99\tp99.9\tmax\tnon-2xx\terrors\t")
+	for _, depth := range depths {
+		var base float64
+		for i, t := range targets {
+			if i > 0 || depth != depths[0] {
+				time.Sleep(cooldown)
+			}
+			log.Printf("running %s with pipeline %d...\n", t.name, depth)
+			res := runLoad(&loadSpec{
+				addr:     t.addr,
+				path:     path,
+				conns:    conns,
+				pipeline: depth,
+				warmup:   warmup,
+				duration: duration,
+				timeout:  timeout,
+			})
+			if i == 0 {
+				base = res.rps()
+			}
+			relative := "-"
+			if base > 0 {
+				relative = fmt.Sprintf("%.2fx", res.rps()/base)
+			}
+			h := &res.latency
+			_, _ = fmt.Fprintf(tw, "%s\t%d\t%d\t%.0f\t%s\t%v\t%v\t%v\t%v\t%v\t%d\t%d\t\n",
+				t.name, depth, res.requests, res.rps(), relative,
+				round(h.percentile(0.5)), round(h.percentile(0.9)), round(h.percentile(0.99)),
+				round(h.percentile(0.999)), round(h.max), res.non2xx, res.errors)
+		}
+	}
+	fmt.Println()
+	_ = tw.Flush()
+}
+
+// round trims a latency to 3 significant digits or so, which is all the histogram keeps anyway.
+func round(d time.Duration) time.Duration {
+	switch {
+	case d >= 10*time.Millisecond:
+		return d.Round(100 * time.Microsecond)
+	case d >= 10*time.Microsecond:
+		return d.Round(time.Microsecond)
+	}
+	return d
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"math/bits"
+	"time"
+)
+
+// subBuckets is the number of buckets each power of two is split into, which bounds the relative error of
+// the recorded latencies to 1/subBuckets, about 1.5%.
+const subBuckets = 64
+
+// histogram counts latencies in log-linear buckets, so that millions of them take a fixed amount of memory
+// and histograms of separate connections merge by adding them up.
+type histogram struct {
+	counts [subBuckets * 59]int64
+	total  int64
+	max    time.Duration
+}
+
+func bucketOf(d time.Duration) int {
+	v := uint64(d)
+	if v < 2*subBuckets {
+		return int(v)
+	}
+	e := bits.Len64(v) - 7
+	return subBuckets*(e+1) + int(v>>uint(e)) - subBuckets
+}
+
+// bucketValue returns the lowest latency counted in bucket i.
+func bucketValue(i int) time.Duration {
+	if i < 2*subBuckets {
+		return time.Duration(i)
+	}
+	e := i/subBuckets - 1
+	return time.Duration(uint64(i%subBuckets+subBuckets) << uint(e))
+}
+
+func (h *histogram) record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	h.counts[bucketOf(d)]++
+	h.total++
+	if d > h.max {
+		h.max = d
+	}
+}
+
+func (h *histogram) merge(o *histogram) {
+	for i, n := range o.counts {
+		h.counts[i] += n
+	}
+	h.total += o.total
+	if o.max > h.max {
+		h.max = o.max
+	}
+}
+
+// percentile returns the latency that a fraction q of the recorded ones don't exceed.
+func (h *histogram) percentile(q float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	rank := int64(q*float64(h.total) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	var seen int64
+	for i, n := range h.counts {
+		if seen += n; seen >= rank {
+			if d := bucketValue(i); d < h.max {
+				return d
+			}
+			return h.max
+		}
+	}
+	return h.max
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	errMalformedResponse = errors.New("malformed response")
+	errNoContentLength   = errors.New("response without Content-Length")
+	errClosedInFlight    = errors.New("connection closed by the server with requests in flight")
+)
+
+// loadSpec describes a run of the load generator against one server.
+type loadSpec struct {
+	addr     string
+	path     string
+	conns    int
+	pipeline int
+	warmup   time.Duration
+	duration time.Duration
+	timeout  time.Duration
+}
+
+// loadResult is what a run measured, over the requests sent after the warmup.
+type loadResult struct {
+	requests int64
+	non2xx   int64
+	errors   int64
+	elapsed  time.Duration
+	latency  histogram
+}
+
+func (r *loadResult) rps() float64 {
+	if r.elapsed <= 0 {
+		return 0
+	}
+	return float64(r.requests) / r.elapsed.Seconds()
+}
+
+// loadConn is a client connection of a run, it sends its requests in batches of spec.pipeline without waiting
+// for the responses in between, and waits for the whole batch to be answered before sending the next one.
+// A pipeline of 1 makes plain keep-alive requests.
+type loadConn struct {
+	spec  *loadSpec
+	batch []byte
+	res   loadResult
+}
+
+// runLoad runs spec against a server and returns the measurements of all its connections.
+func runLoad(spec *loadSpec) *loadResult {
+	req := []byte("GET " + spec.path + " HTTP/1.1\r\nHost: " + spec.addr + "\r\nUser-Agent: gnet-bench\r\n\r\n")
+	batch := bytes.Repeat(req, spec.pipeline)
+	start := time.Now()
+	measureFrom, end := start.Add(spec.warmup), start.Add(spec.warmup+spec.duration)
+
+	lcs := make([]*loadConn, spec.conns)
+	var wg sync.WaitGroup
+	for i := range lcs {
+		lcs[i] = &loadConn{spec: spec, batch: batch}
+		wg.Add(1)
+		go func(lc *loadConn) {
+			defer wg.Done()
+			lc.run(measureFrom, end)
+		}(lcs[i])
+	}
+	wg.Wait()
+
+	// The batches sent until the end are accounted for, including the time it took to answer the last ones.
+	res := &loadResult{elapsed: time.Since(measureFrom)}
+	for _, lc := range lcs {
+		res.requests += lc.res.requests
+		res.non2xx += lc.res.non2xx
+		res.errors += lc.res.errors
+		res.latency.merge(&lc.res.latency)
+	}
+	return res
+}
+
+// run keeps a connection busy until end, reconnecting after errors, only the batches sent from measureFrom on
+// are accounted for.
+func (lc *loadConn) run(measureFrom, end time.Time) {
+	for time.Now().Before(end) {
+		c, err := net.DialTimeout("tcp", lc.spec.addr, lc.spec.timeout)
+		if err != nil {
+			lc.res.errors++
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		if err = lc.serve(c, measureFrom, end); err != nil && time.Now().Before(end) {
+			lc.res.errors++
+		}
+		_ = c.Close()
+	}
+}
+
+func (lc *loadConn) serve(c net.Conn, measureFrom, end time.Time) error {
+	br := bufio.NewReaderSize(c, 64<<10)
+	for {
+		sent := time.Now()
+		if !sent.Before(end) {
+			return nil
+		}
+		_ = c.SetDeadline(sent.Add(lc.spec.timeout))
+		if _, err := c.Write(lc.batch); err != nil {
+			return err
+		}
+		measured := !sent.Before(measureFrom)
+		for i := 0; i < lc.spec.pipeline; i++ {
+			status, closing, err := readResponse(br)
+			if err != nil {
+				return err
+			}
+			if measured {
+				lc.res.latency.record(time.Since(sent))
+				lc.res.requests++
+				if status < 200 || status > 299 {
+					lc.res.non2xx++
+				}
+			}
+			if closing {
+				if i < lc.spec.pipeline-1 {
+					return errClosedInFlight
+				}
+				return nil
+			}
+		}
+	}
+}
+
+// readResponse reads a response off br and returns its status and whether the server is closing the connection,
+// the body is skipped. Only bodies framed by Content-Length are supported, which both servers use for the
+// routes worth benchmarking.
+func readResponse(br *bufio.Reader) (status int, closing bool, err error) {
+	line, err := br.ReadSlice('\n')
+	if err != nil {
+		return 0, false, err
+	}
+	if len(line) < 12 || !bytes.HasPrefix(line, []byte("HTTP/1.")) {
+		return 0, false, errMalformedResponse
+	}
+	if status, err = strconv.Atoi(string(line[9:12])); err != nil {
+		return 0, false, errMalformedResponse
+	}
+	length := -1
+	for {
+		if line, err = br.ReadSlice('\n'); err != nil {
+			return 0, false, err
+		}
+		line = bytes.TrimRight(line, "\r\n")
+		if len(line) == 0 {
+			break
+		}
+		i := bytes.IndexByte(line, ':')
+		if i < 0 {
+			return 0, false, errMalformedResponse
+		}
+		name, value := line[:i], bytes.TrimSpace(line[i+1:])
+		switch {
+		case bytes.EqualFold(name, []byte("Content-Length")):
+			if length, err = strconv.Atoi(string(value)); err != nil || length < 0 {
+				return 0, false, errMalformedResponse
+			}
+		case bytes.EqualFold(name, []byte("Connection")):
+			closing = bytes.EqualFold(value, []byte("close"))
+		}
+	}
+	if length < 0 {
+		return 0, false, errNoContentLength
+	}
+	if _, err = br.Discard(length); err != nil {
+		return 0, false, err
+	}
+	return status, closing, nil
+}
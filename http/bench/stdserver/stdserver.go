@@ -0,0 +1,57 @@
+// Command stdserver is the net/http counterpart of the gnet HTTP server, for the benchmark: it serves the same
+// plain routes with the same responses, so that the two can be compared request for request. The gnet server
+// is to run with --secure_headers=false --request_id=false for that, its middlewares are left out here.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+)
+
+func main() {
+	var port int
+
+	// Example command: go run . --port 9081
+	flag.IntVar(&port, "port", 9081, "server port")
+	flag.Parse()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Server", "net/http")
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("Hello World!"))
+	})
+	mux.HandleFunc("/hello/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "net/http")
+		_, _ = fmt.Fprintf(w, "Hello, %s!\n", strings.TrimPrefix(r.URL.Path, "/hello/"))
+	})
+	mux.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "405 method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "400 bad request", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Server", "net/http")
+		if ct := r.Header.Get("Content-Type"); ct != "" {
+			w.Header().Set("Content-Type", ct)
+		}
+		_, _ = w.Write(body)
+	})
+
+	addr := fmt.Sprintf("127.0.0.1:%d", port)
+	log.Printf("net/http server is listening on %s\n", addr)
+	log.Println("server exits:", http.ListenAndServe(addr, mux))
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+var errBodyAborted = errors.New("request body aborted")
+
+// BodySink receives the body of a request as it arrives, see StreamHandler.
+type BodySink interface {
+	// Write is called with each piece of the body, p references a buffer of the connection and is only valid
+	// during the call. An error fails the request, with the status of a *statusError or else a 500.
+	Write(p []byte) (int, error)
+	// Abort is called if the body won't be complete, because it's malformed or too large, the sink failed,
+	// or the client went away. ServeHTTP runs instead once the whole body has been written.
+	Abort(err error)
+}
+
+// StreamHandler is a Handler whose request bodies are fed to a BodySink as they arrive rather than buffered
+// in memory first, so that uploads larger than the max body size of the server can go straight to disk.
+type StreamHandler interface {
+	Handler
+	// OpenBody is called once the head of r has been received and returns the sink of its body, along with
+	// the max size of that body, 0 meaning unlimited, a larger one gets a 413. An error fails the request as
+	// with BodySink.Write. ServeHTTP runs once the body is complete, with r.Sink set and r.Body empty;
+	// OpenBody isn't called for a request without a body, r.Sink is nil then.
+	OpenBody(r *Request) (BodySink, int64, error)
+}
+
+// limitedHandler is a handler with a max body size of its own, see MaxBody.
+type limitedHandler struct {
+	Handler
+	maxBody int64
+}
+
+// MaxBody returns a handler running h with request bodies limited to maxBody bytes instead of the max body
+// size of the server, 0 meaning unlimited, a larger body gets a 413.
+func MaxBody(h Handler, maxBody int64) Handler {
+	return &limitedHandler{h, maxBody}
+}
+
+// hasBodyPolicy reports whether h has a say in how its request bodies are received.
+func hasBodyPolicy(h Handler) bool {
+	switch h.(type) {
+	case StreamHandler, *limitedHandler:
+		return true
+	}
+	return false
+}
+
+// bodyRoute returns the handler of the route matching method and path if it has a say in how request bodies
+// are received, nil otherwise. Only the routes of the router of the server have a say, middlewares don't.
+func (hs *httpServer) bodyRoute(method, path string) Handler {
+	if hs.router == nil || hs.router.bodyPolicies == 0 {
+		return nil
+	}
+	if h := hs.router.lookup(method, path); hasBodyPolicy(h) {
+		return h
+	}
+	return nil
+}
+
+// openBody returns the max size of the body of r, whose head has just been received and whose route is h
+// as returned by bodyRoute, and opens the sink of the body if h is a StreamHandler.
+func (hs *httpServer) openBody(h Handler, r *Request) (int64, error) {
+	switch h := h.(type) {
+	case StreamHandler:
+		sink, maxBody, err := h.OpenBody(r)
+		if err != nil {
+			return 0, sinkError(err)
+		}
+		r.Sink = sink
+		return maxBody, nil
+	case *limitedHandler:
+		return h.maxBody, nil
+	}
+	return hs.maxBody, nil
+}
+
+// sinkError is the error a request fails with when the sink of its body does.
+func sinkError(err error) error {
+	if _, ok := err.(*statusError); ok {
+		return err
+	}
+	return &statusError{http.StatusInternalServerError, err.Error()}
+}
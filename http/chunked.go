@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+)
+
+var (
+	errBadChunkedEncoding = &statusError{http.StatusBadRequest, "malformed chunked encoding"}
+	errBodyTooLarge       = &statusError{http.StatusRequestEntityTooLarge, "request body too large"}
+	errTrailerTooLarge    = &statusError{http.StatusRequestHeaderFieldsTooLarge, "request trailer too large"}
+)
+
+const (
+	// maxChunkLineSize bounds a chunk-size line including its extensions.
+	maxChunkLineSize = 4096
+	// maxTrailerSize bounds the trailer section of a chunked body.
+	maxTrailerSize = 64 << 10
+)
+
+type chunkedState int
+
+const (
+	chunkSizeLine chunkedState = iota
+	chunkData
+	chunkDataCRLF
+	chunkTrailer
+	chunkDone
+)
+
+// chunkedDecoder decodes a chunked request body incrementally, see RFC 9112, section 7.1.
+// It consumes raw bytes as they arrive, so that they can be discarded from the inbound buffer of
+// the connection, and accumulates the decoded body and trailer fields until the last chunk.
+// With a sink, the body is written to the sink instead of being accumulated.
+type chunkedDecoder struct {
+	state        chunkedState
+	remaining    int64
+	maxBody      int64
+	size         int64
+	sink         BodySink
+	body         []byte
+	trailer      http.Header
+	trailerBytes int
+}
+
+func (cd *chunkedDecoder) reset(maxBody int64, sink BodySink) {
+	cd.state = chunkSizeLine
+	cd.remaining = 0
+	cd.maxBody = maxBody
+	cd.size = 0
+	cd.sink = sink
+	cd.body = cd.body[:0]
+	cd.trailer = nil
+	cd.trailerBytes = 0
+}
+
+func (cd *chunkedDecoder) done() bool {
+	return cd.state == chunkDone
+}
+
+// feed decodes as much of data as it can and returns how many bytes it consumed,
+// a partially received line is left unconsumed until the rest of it arrives.
+func (cd *chunkedDecoder) feed(data []byte) (n int, err error) {
+	for n < len(data) && cd.state != chunkDone {
+		switch cd.state {
+		case chunkSizeLine:
+			line, m, err := nextLine(data[n:])
+			if err != nil {
+				return n, err
+			}
+			if m == 0 {
+				if len(data)-n > maxChunkLineSize {
+					return n, errBadChunkedEncoding
+				}
+				return n, nil
+			}
+			if len(line) > maxChunkLineSize {
+				return n, errBadChunkedEncoding
+			}
+			n += m
+			size, err := parseChunkSize(line)
+			if err != nil {
+				return n, err
+			}
+			if cd.maxBody > 0 && cd.size+size > cd.maxBody {
+				return n, errBodyTooLarge
+			}
+			if size == 0 {
+				cd.state = chunkTrailer
+				continue
+			}
+			cd.remaining = size
+			cd.state = chunkData
+		case chunkData:
+			m := len(data) - n
+			if int64(m) > cd.remaining {
+				m = int(cd.remaining)
+			}
+			if cd.sink == nil {
+				cd.body = append(cd.body, data[n:n+m]...)
+			} else if _, err = cd.sink.Write(data[n : n+m]); err != nil {
+				return n, sinkError(err)
+			}
+			cd.size += int64(m)
+			cd.remaining -= int64(m)
+			n += m
+			if cd.remaining == 0 {
+				cd.state = chunkDataCRLF
+			}
+		case chunkDataCRLF:
+			if len(data)-n < 2 {
+				if data[n] != '\r' {
+					return n, errBadChunkedEncoding
+				}
+				return n, nil
+			}
+			if data[n] != '\r' || data[n+1] != '\n' {
+				return n, errBadChunkedEncoding
+			}
+			n += 2
+			cd.state = chunkSizeLine
+		case chunkTrailer:
+			line, m, err := nextLine(data[n:])
+			if err != nil {
+				return n, err
+			}
+			if m == 0 {
+				if cd.trailerBytes+len(data)-n > maxTrailerSize {
+					return n, errTrailerTooLarge
+				}
+				return n, nil
+			}
+			n += m
+			cd.trailerBytes += m
+			if cd.trailerBytes > maxTrailerSize {
+				return n, errTrailerTooLarge
+			}
+			if len(line) == 0 {
+				cd.state = chunkDone
+				continue
+			}
+			if err = cd.addTrailer(line); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// addTrailer parses a trailer field line the way the header parser does a header field line, the fields
+// that aren't allowed in trailers are dropped.
+func (cd *chunkedDecoder) addTrailer(line []byte) error {
+	i := bytes.IndexByte(line, ':')
+	if i <= 0 || !isToken(line[:i]) {
+		return errBadChunkedEncoding
+	}
+	value := bytes.Trim(line[i+1:], " \t")
+	if !isFieldValue(value) {
+		return errBadChunkedEncoding
+	}
+	name := http.CanonicalHeaderKey(string(line[:i]))
+	if forbiddenTrailers[name] {
+		return nil
+	}
+	if cd.trailer == nil {
+		cd.trailer = make(http.Header)
+	}
+	cd.trailer[name] = append(cd.trailer[name], string(value))
+	return nil
+}
+
+// forbiddenTrailers are the fields a sender must not put in trailers, as they frame or route the message,
+// modify the request, or are needed before the content is processed, see RFC 9110, section 6.5.1.
+var forbiddenTrailers = map[string]bool{
+	"Authorization":       true,
+	"Cache-Control":       true,
+	"Connection":          true,
+	"Content-Encoding":    true,
+	"Content-Length":      true,
+	"Content-Range":       true,
+	"Content-Type":        true,
+	"Expect":              true,
+	"Host":                true,
+	"Keep-Alive":          true,
+	"Max-Forwards":        true,
+	"Pragma":              true,
+	"Proxy-Authenticate":  true,
+	"Proxy-Authorization": true,
+	"Proxy-Connection":    true,
+	"Range":               true,
+	"Set-Cookie":          true,
+	"Te":                  true,
+	"Trailer":             true,
+	"Transfer-Encoding":   true,
+	"Upgrade":             true,
+	"Www-Authenticate":    true,
+}
+
+// nextLine returns the line at the start of data without its CRLF and the number of bytes it spans,
+// m is 0 if data holds no complete line yet. Unlike the request head, the chunked framing takes no bare LF
+// for a line ending: like the CRLF after chunk data, it is one a front proxy may not split lines at.
+func nextLine(data []byte) (line []byte, m int, err error) {
+	i := bytes.IndexByte(data, '\n')
+	if i < 0 {
+		return nil, 0, nil
+	}
+	if i == 0 || data[i-1] != '\r' {
+		return nil, 0, errBadChunkedEncoding
+	}
+	return data[:i-1], i + 1, nil
+}
+
+// parseChunkSize parses a chunk-size line, 1*HEXDIG, chunk extensions are ignored. No sign nor 0x prefix
+// is allowed, a front proxy wouldn't read the size the same.
+func parseChunkSize(line []byte) (int64, error) {
+	if i := bytes.IndexByte(line, ';'); i >= 0 {
+		line = line[:i]
+	}
+	line = bytes.TrimRight(line, " \t")
+	if len(line) == 0 || len(line) > 15 {
+		return 0, errBadChunkedEncoding
+	}
+	var size int64
+	for _, b := range line {
+		switch {
+		case '0' <= b && b <= '9':
+			b -= '0'
+		case 'a' <= b && b <= 'f':
+			b -= 'a' - 10
+		case 'A' <= b && b <= 'F':
+			b -= 'A' - 10
+		default:
+			return 0, errBadChunkedEncoding
+		}
+		size = size<<4 | int64(b)
+	}
+	return size, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestChunkedTrailer(t *testing.T) {
+	tests := []struct {
+		name    string
+		trailer string
+		want    http.Header
+		err     error
+	}{
+		{"none", "", nil, nil},
+		{"fields", "X-Checksum: 42\r\nx-note:\t a b \r\nX-Checksum: 43\r\n",
+			http.Header{"X-Checksum": {"42", "43"}, "X-Note": {"a b"}}, nil},
+		{"forbidden dropped", "Content-Length: 5\r\nHost: evil.example\r\nTransfer-Encoding: chunked\r\nX-Ok: 1\r\n",
+			http.Header{"X-Ok": {"1"}}, nil},
+		{"space before colon", "X-Bad : 1\r\n", nil, errBadChunkedEncoding},
+		{"invalid name", "X\x01Bad: 1\r\n", nil, errBadChunkedEncoding},
+		{"bare CR in value", "X-Bad: a\rSet-Cookie: b\r\n", nil, errBadChunkedEncoding},
+		{"folded line", "X-Ok: 1\r\n folded\r\n", nil, errBadChunkedEncoding},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cd chunkedDecoder
+			cd.reset(0, nil)
+			data := []byte("3\r\nabc\r\n0\r\n" + tt.trailer + "\r\n")
+			n, err := cd.feed(data)
+			if err != tt.err {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if n != len(data) || !cd.done() || string(cd.body) != "abc" {
+				t.Fatalf("consumed %d of %d bytes, done: %t, body %q", n, len(data), cd.done(), cd.body)
+			}
+			if !reflect.DeepEqual(cd.trailer, tt.want) {
+				t.Fatalf("got trailer %v, want %v", cd.trailer, tt.want)
+			}
+		})
+	}
+}
+
+func TestChunkedFraming(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		body string
+		err  error
+	}{
+		{"sizes", "5\r\nhello\r\nA;ext=1\r\n0123456789\r\n0\r\n\r\n", "hello0123456789", nil},
+		{"upper hex", "1F  \r\n0123456789012345678901234567890\r\n0\r\n\r\n", "0123456789012345678901234567890", nil},
+		{"plus sign", "+5\r\nhello\r\n0\r\n\r\n", "", errBadChunkedEncoding},
+		{"minus zero", "-0\r\n\r\n", "", errBadChunkedEncoding},
+		{"hex prefix", "0x5\r\nhello\r\n0\r\n\r\n", "", errBadChunkedEncoding},
+		{"underscore", "1_0\r\n", "", errBadChunkedEncoding},
+		{"empty size", "\r\nhello\r\n0\r\n\r\n", "", errBadChunkedEncoding},
+		{"bare LF after size", "5\nhello\r\n0\r\n\r\n", "", errBadChunkedEncoding},
+		{"bare LF after data", "5\r\nhello\n0\r\n\r\n", "", errBadChunkedEncoding},
+		{"bare LF in trailer", "0\r\nX-Ok: 1\n\r\n", "", errBadChunkedEncoding},
+		{"bare LF ending", "0\r\n\n", "", errBadChunkedEncoding},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cd chunkedDecoder
+			cd.reset(0, nil)
+			n, err := cd.feed([]byte(tt.data))
+			if err != tt.err {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if n != len(tt.data) || !cd.done() || string(cd.body) != tt.body {
+				t.Fatalf("consumed %d of %d bytes, done: %t, body %q", n, len(tt.data), cd.done(), cd.body)
+			}
+		})
+	}
+}
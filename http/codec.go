@@ -0,0 +1,221 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/panjf2000/gnet/v2"
+)
+
+var errIncompleteRequest = errors.New("incomplete request")
+
+type httpCodec struct {
+	srv     *httpServer
+	conn    gnet.Conn
+	parser  requestParser
+	maxBody int64
+	buf     []byte
+	header  http.Header
+	resp    response
+
+	// route is the handler of the request being parsed if it has a say in how the body is received, see bodyRoute.
+	route Handler
+
+	// pending is a request whose head has been consumed while its body is still arriving, chunked or fed to
+	// a BodySink, bodyLeft being the number of bytes left of a body with a Content-Length, or -1 if chunked.
+	pending  *Request
+	chunked  chunkedDecoder
+	bodyLeft int64
+
+	// busy is set while a detached response is being produced off the event-loop,
+	// unread is the number of inbound bytes left over for when it's done.
+	busy   bool
+	unread int
+
+	// requests counts the requests served on the connection, closing is set once a response
+	// has asked for the connection to be closed, no further request is served then.
+	requests int
+	closing  bool
+
+	// upgrade is the WebSocket connection that takes over once a handler has switched protocols,
+	// no further request is served then.
+	upgrade *wsConn
+
+	// closed is closed along with the connection, for the goroutines working on its responses.
+	closed chan struct{}
+
+	// idleSince is the UnixNano time since when the connection has had no request in progress, or 0 if it
+	// has one, waitingSince the one since when the request numbered waitingFor has been partially received,
+	// or 0 if there is none. Both are read by the ticker.
+	idleSince    int64
+	waitingSince int64
+	waitingFor   int
+}
+
+func newHTTPCodec(c gnet.Conn, srv *httpServer) *httpCodec {
+	hc := &httpCodec{srv: srv, conn: c, header: make(http.Header), maxBody: srv.maxBody, closed: make(chan struct{})}
+	hc.parser.maxRequestLine = srv.maxRequestLine
+	hc.parser.maxHeaderCount = srv.maxHeaderCount
+	hc.parser.maxHeaderBytes = srv.maxHeaderBytes
+	hc.parser.reset()
+	return hc
+}
+
+// parse parses the request at the start of data and returns its total length, head and body included,
+// or errIncompleteRequest if more data is needed to tell. For a request whose body is consumed as it arrives,
+// a chunked one or one going to a BodySink, only the head is accounted for.
+func (hc *httpCodec) parse(data []byte) (n int, err error) {
+	headLen, err := hc.parser.parse(data)
+	if err != nil {
+		return 0, err
+	}
+	hc.route = nil
+	p := &hc.parser
+	if p.chunked || p.contentLength > 0 {
+		path, _ := splitTarget(string(p.target.of(data)))
+		hc.route = hc.srv.bodyRoute(string(p.method.of(data)), path)
+	}
+	if _, ok := hc.route.(StreamHandler); ok || p.chunked {
+		return headLen, nil
+	}
+	bodyLen := p.contentLength
+	if bodyLen < 0 {
+		bodyLen = 0
+	}
+	maxBody := hc.maxBody
+	if lh, ok := hc.route.(*limitedHandler); ok {
+		maxBody = lh.maxBody
+	}
+	if maxBody > 0 && bodyLen > maxBody {
+		return 0, errBodyTooLarge
+	}
+	n = headLen + int(bodyLen)
+	if len(data) < n {
+		return 0, errIncompleteRequest
+	}
+	return n, nil
+}
+
+// decode serves every complete request at the start of buf and returns how many bytes it consumed,
+// it stops early when a handler detaches its response, the rest is served once that response is done.
+func (hc *httpCodec) decode(h Handler, buf []byte) (consumed int, err error) {
+	for consumed < len(buf) && !hc.busy && !hc.closing && hc.upgrade == nil {
+		if hc.pending != nil {
+			n, done, err := hc.feedBody(buf[consumed:])
+			consumed += n
+			if err != nil {
+				hc.abortBody(err)
+				return consumed, err
+			}
+			if !done {
+				break
+			}
+			req := hc.pending
+			hc.pending = nil
+			if hc.bodyLeft < 0 {
+				if req.Sink == nil {
+					req.Body = hc.chunked.body
+				}
+				req.ContentLength = hc.chunked.size
+				req.Trailer = hc.chunked.trailer
+			}
+			hc.serve(h, req)
+			continue
+		}
+
+		n, err := hc.parse(buf[consumed:])
+		if err == errIncompleteRequest {
+			break
+		}
+		if err != nil {
+			return consumed, err
+		}
+		data := buf[consumed:]
+		if _, ok := hc.route.(StreamHandler); ok || hc.parser.chunked {
+			// The head is turned into a Request right away, so that its bytes can be discarded
+			// while the body is decoded into a buffer of its own, or written to a BodySink.
+			req := newRequest(&hc.parser, data, hc.header, nil, hc.conn.RemoteAddr().String())
+			consumed += n
+			if err := hc.openBody(req); err != nil {
+				return consumed, err
+			}
+			continue
+		}
+		req := newRequest(&hc.parser, data, hc.header, data[hc.parser.pos:n], hc.conn.RemoteAddr().String())
+		hc.parser.reset()
+		hc.serve(h, req)
+		consumed += n
+	}
+	return consumed, nil
+}
+
+// openBody prepares for the body of req to be received, its head having just been consumed.
+func (hc *httpCodec) openBody(req *Request) error {
+	chunked, length, route := hc.parser.chunked, hc.parser.contentLength, hc.route
+	hc.parser.reset()
+	hc.route = nil
+	maxBody, err := hc.srv.openBody(route, req)
+	if err != nil {
+		return err
+	}
+	if !chunked && maxBody > 0 && length > maxBody {
+		req.Sink.Abort(errBodyTooLarge)
+		return errBodyTooLarge
+	}
+	hc.pending = req
+	if chunked {
+		hc.bodyLeft = -1
+		hc.chunked.reset(maxBody, req.Sink)
+	} else {
+		hc.bodyLeft = length
+		req.ContentLength = length
+	}
+	return nil
+}
+
+// feedBody consumes what it can of the body of the pending request at the start of data,
+// it reports whether the body is complete.
+func (hc *httpCodec) feedBody(data []byte) (n int, done bool, err error) {
+	if hc.bodyLeft < 0 {
+		n, err = hc.chunked.feed(data)
+		return n, hc.chunked.done(), err
+	}
+	n = len(data)
+	if int64(n) > hc.bodyLeft {
+		n = int(hc.bodyLeft)
+	}
+	if _, err = hc.pending.Sink.Write(data[:n]); err != nil {
+		return n, false, sinkError(err)
+	}
+	hc.bodyLeft -= int64(n)
+	return n, hc.bodyLeft == 0, nil
+}
+
+// abortBody gives up on the pending request, telling its sink why.
+func (hc *httpCodec) abortBody(err error) {
+	if hc.pending != nil && hc.pending.Sink != nil {
+		hc.pending.Sink.Abort(err)
+	}
+	hc.pending = nil
+}
+
+// serve runs the handler for req and appends its response to the output buffer.
+func (hc *httpCodec) serve(h Handler, req *Request) {
+	hc.requests++
+	req.hc = hc
+	w := &hc.resp
+	w.reset(hc, req)
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("panic serving %s %s: %v\n", req.Method, req.RequestURI, p)
+			w.fail()
+		}
+		// The protocol is only switched if the 101 made it out.
+		if hc.upgrade != nil && (hc.closing || w.detached || w.statusCode() != http.StatusSwitchingProtocols) {
+			hc.upgrade = nil
+		}
+	}()
+	h.ServeHTTP(w, req)
+	w.finish()
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/panjf2000/gnet/v2"
+)
+
+// testConn stands in for the connection of a codec driven directly by a test, only RemoteAddr is implemented.
+type testConn struct {
+	gnet.Conn
+}
+
+func (testConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40000}
+}
+
+func newTestServer(h Handler) *httpServer {
+	hs := &httpServer{
+		handler:        h,
+		maxBody:        1 << 20,
+		maxRequestLine: 8 << 10,
+		maxHeaderCount: 100,
+		maxHeaderBytes: 64 << 10,
+	}
+	hs.date.update(time.Now())
+	return hs
+}
+
+// parsedRequest is what a handler saw of a request, copied as the Request only lives as long as ServeHTTP.
+type parsedRequest struct {
+	Method  string
+	Path    string
+	Query   string
+	Proto   string
+	Host    string
+	Header  http.Header
+	Body    string
+	Trailer http.Header
+}
+
+// decodePieces feeds the pieces to a codec one read at a time, the way the inbound buffer of a connection
+// receives them: what a call leaves unconsumed is passed again, followed by the next piece.
+func decodePieces(t *testing.T, pieces ...[]byte) []parsedRequest {
+	t.Helper()
+	var got []parsedRequest
+	h := HandlerFunc(func(w ResponseWriter, r *Request) {
+		got = append(got, parsedRequest{
+			Method:  r.Method,
+			Path:    r.Path,
+			Query:   r.RawQuery,
+			Proto:   r.Proto,
+			Host:    r.Host,
+			Header:  r.Header.Clone(),
+			Body:    string(r.Body),
+			Trailer: r.Trailer,
+		})
+		_, _ = w.Write([]byte("ok"))
+	})
+	hc := newHTTPCodec(testConn{}, newTestServer(h))
+	var buf []byte
+	for _, p := range pieces {
+		buf = append(buf, p...)
+		n, err := hc.decode(h, buf)
+		if err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		buf = append([]byte(nil), buf[n:]...)
+	}
+	if len(buf) != 0 || hc.pending != nil {
+		t.Fatalf("%d bytes left over, pending request: %t", len(buf), hc.pending != nil)
+	}
+	return got
+}
+
+var splitSamples = []struct {
+	name     string
+	data     string
+	requests int
+}{
+	{"get", "GET /hello/gnet?lang=go HTTP/1.1\r\nHost: example.com\r\nUser-Agent: test\r\nAccept: */*\r\n\r\n", 1},
+	{"http10", "GET / HTTP/1.0\r\n\r\n", 1},
+	{"bare LF", "GET /lf HTTP/1.1\nHost: example.com\nX-Empty:\n\n", 1},
+	{"leading empty lines", "\r\n\r\nGET / HTTP/1.1\r\nHost: example.com\r\n\r\n", 1},
+	{"repeated fields", "GET / HTTP/1.1\r\nHost: example.com\r\nAccept: text/html\r\nAccept: text/plain\r\n\r\n", 1},
+	{"content-length", "POST /echo HTTP/1.1\r\nHost: example.com\r\nContent-Type: text/plain\r\nContent-Length: 11\r\n\r\nhello world", 1},
+	{"chunked", "POST /echo HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"5\r\nhello\r\n1;ext=1\r\n \r\n5\r\nworld\r\n0\r\nX-Checksum: 42\r\n\r\n", 1},
+	{"pipelined", "GET /a HTTP/1.1\r\nHost: example.com\r\n\r\n" +
+		"POST /b HTTP/1.1\r\nHost: example.com\r\nContent-Length: 3\r\n\r\nabc" +
+		"POST /c HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: chunked\r\n\r\n3\r\ndef\r\n0\r\n\r\n" +
+		"GET /d HTTP/1.1\r\nHost: example.com\r\n\r\n", 4},
+}
+
+// TestDecodeSplit checks that a request is parsed the same wherever the reads split it.
+func TestDecodeSplit(t *testing.T) {
+	for _, s := range splitSamples {
+		t.Run(s.name, func(t *testing.T) {
+			data := []byte(s.data)
+			want := decodePieces(t, data)
+			if len(want) != s.requests {
+				t.Fatalf("got %d requests, want %d", len(want), s.requests)
+			}
+			for i := 1; i < len(data); i++ {
+				got := decodePieces(t, data[:i], data[i:])
+				if !reflect.DeepEqual(got, want) {
+					t.Fatalf("split at %d:\ngot  %+v\nwant %+v", i, got, want)
+				}
+			}
+			pieces := make([][]byte, len(data))
+			for i := range data {
+				pieces[i] = data[i : i+1]
+			}
+			if got := decodePieces(t, pieces...); !reflect.DeepEqual(got, want) {
+				t.Fatalf("byte by byte:\ngot  %+v\nwant %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestDecodeRequest(t *testing.T) {
+	got := decodePieces(t, []byte(splitSamples[0].data+splitSamples[6].data))
+	want := []parsedRequest{
+		{
+			Method: "GET", Path: "/hello/gnet", Query: "lang=go", Proto: "HTTP/1.1", Host: "example.com",
+			Header: http.Header{"Host": {"example.com"}, "User-Agent": {"test"}, "Accept": {"*/*"}},
+		},
+		{
+			Method: "POST", Path: "/echo", Proto: "HTTP/1.1", Host: "example.com",
+			Header:  http.Header{"Host": {"example.com"}, "Transfer-Encoding": {"chunked"}},
+			Body:    "hello world",
+			Trailer: http.Header{"X-Checksum": {"42"}},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got  %+v\nwant %+v", got, want)
+	}
+}
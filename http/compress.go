@@ -0,0 +1,276 @@
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/panjf2000/gnet/v2"
+)
+
+// encoder is the part of gzip.Writer and zlib.Writer that compressWriter uses.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// compressor holds the encoders of a compression middleware for reuse, in pools shared by the whole process.
+// They aren't kept per event-loop: gnet doesn't tell which event-loop a connection belongs to, and detached
+// responses are compressed on worker goroutines anyway. A sync.Pool is safe from any goroutine, and caches
+// its items per P, which is as close as it gets.
+type compressor struct {
+	minSize int
+	gzip    sync.Pool
+	deflate sync.Pool
+}
+
+// compress returns a middleware that compresses response bodies of at least minSize bytes with gzip or deflate,
+// as negotiated with Accept-Encoding, at the given compress/flate level. Content types that are compressed
+// already are left alone, and so are partial responses and responses that carry a Content-Encoding.
+func compress(level, minSize int) Middleware {
+	c := &compressor{minSize: minSize}
+	c.gzip.New = func() interface{} {
+		w, err := gzip.NewWriterLevel(nil, level)
+		if err != nil {
+			panic(err)
+		}
+		return w
+	}
+	c.deflate.New = func() interface{} {
+		w, err := zlib.NewWriterLevel(nil, level)
+		if err != nil {
+			panic(err)
+		}
+		return w
+	}
+	return func(next Handler) Handler {
+		return HandlerFunc(func(w ResponseWriter, r *Request) {
+			cw := &compressWriter{w: w, c: c, coding: negotiateEncoding(r.Header["Accept-Encoding"])}
+			if r.Method == http.MethodHead {
+				cw.coding = ""
+			}
+			next.ServeHTTP(cw, r)
+			if !cw.detached {
+				cw.close()
+			}
+		})
+	}
+}
+
+func (c *compressor) pool(coding string) *sync.Pool {
+	if coding == "gzip" {
+		return &c.gzip
+	}
+	return &c.deflate
+}
+
+// compressWriter holds the body back until it knows whether to compress it: when the handler returns,
+// when the body reaches minSize or when the handler flushes.
+type compressWriter struct {
+	w       ResponseWriter
+	c       *compressor
+	coding  string
+	status  int
+	buf     []byte
+	decided bool
+	enc     encoder
+
+	// detached is set once the response has been handed over, to the compressWriter returned by detachWriter.
+	detached bool
+}
+
+func (cw *compressWriter) Header() http.Header {
+	return cw.w.Header()
+}
+
+func (cw *compressWriter) WriteHeader(statusCode int) {
+	if cw.status == 0 {
+		cw.status = statusCode
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	if !cw.decided {
+		cw.buf = append(cw.buf, p...)
+		if len(cw.buf) >= cw.c.minSize {
+			cw.decide(false)
+		}
+		return len(p), nil
+	}
+	if cw.enc != nil {
+		return cw.enc.Write(p)
+	}
+	return cw.w.Write(p)
+}
+
+// Flush compresses whatever has been written so far, a streamed body of a compressible type
+// is compressed whatever its size.
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		cw.decide(true)
+	}
+	if cw.enc != nil {
+		_ = cw.enc.Flush()
+	}
+	cw.w.Flush()
+}
+
+// decide settles on compressing the body or not, and passes the headers and the buffered body on.
+func (cw *compressWriter) decide(streaming bool) {
+	cw.decided = true
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	h := cw.w.Header()
+	if h.Get("Content-Type") == "" && len(cw.buf) > 0 && bodyAllowed(cw.status) {
+		h.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+	eligible := bodyAllowed(cw.status) && cw.status != http.StatusPartialContent &&
+		h.Get("Content-Encoding") == "" && compressible(h.Get("Content-Type")) &&
+		(streaming || len(cw.buf) >= cw.c.minSize)
+	if eligible {
+		// Caches must not serve the compressed body to clients that didn't ask for it, or vice versa.
+		if !hasToken(h["Vary"], "Accept-Encoding") {
+			h.Add("Vary", "Accept-Encoding")
+		}
+		if cw.coding != "" {
+			h.Del("Content-Length")
+			h.Set("Content-Encoding", cw.coding)
+			// A strong validator can't be shared by two encodings of a representation.
+			if etag := h.Get("ETag"); strings.HasPrefix(etag, `"`) {
+				h.Set("ETag", "W/"+etag)
+			}
+			cw.enc = cw.c.pool(cw.coding).Get().(encoder)
+			cw.enc.Reset(cw.w)
+		}
+	}
+	cw.w.WriteHeader(cw.status)
+	if len(cw.buf) > 0 {
+		_, _ = cw.Write(cw.buf)
+	}
+	cw.buf = nil
+}
+
+// close completes the body once the handler has returned.
+func (cw *compressWriter) close() {
+	if !cw.decided {
+		cw.decide(false)
+	}
+	if cw.enc != nil {
+		_ = cw.enc.Close()
+		cw.enc.Reset(nil)
+		cw.c.pool(cw.coding).Put(cw.enc)
+		cw.enc = nil
+	}
+}
+
+// detachWriter hands the response over along with the compression, if the wrapped ResponseWriter can be.
+func (cw *compressWriter) detachWriter() asyncWriter {
+	d, ok := cw.w.(detacher)
+	if !ok || cw.decided {
+		return nil
+	}
+	w := d.detachWriter()
+	if w == nil {
+		return nil
+	}
+	cw.detached = true
+	return &compressWriter{w: w, c: cw.c, coding: cw.coding, status: cw.status, buf: cw.buf}
+}
+
+func (cw *compressWriter) finish() {
+	cw.close()
+	cw.w.(asyncWriter).finish()
+}
+
+func (cw *compressWriter) codec() *httpCodec {
+	return cw.w.(asyncWriter).codec()
+}
+
+func (cw *compressWriter) backlog(c gnet.Conn) (int, error) {
+	return cw.w.(asyncWriter).backlog(c)
+}
+
+func (cw *compressWriter) fail() {
+	if cw.enc != nil {
+		cw.enc.Reset(nil)
+		cw.c.pool(cw.coding).Put(cw.enc)
+		cw.enc = nil
+	}
+	cw.w.(asyncWriter).fail()
+}
+
+// compressible reports whether a body of the given content type is worth compressing,
+// that is whether it isn't compressed already.
+func compressible(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case mt == "image/svg+xml":
+		return true
+	case strings.HasPrefix(mt, "image/"), strings.HasPrefix(mt, "audio/"), strings.HasPrefix(mt, "video/"),
+		strings.HasPrefix(mt, "font/woff"):
+		return false
+	}
+	switch mt {
+	case "application/zip", "application/gzip", "application/x-gzip", "application/x-bzip2", "application/x-xz",
+		"application/x-7z-compressed", "application/x-rar-compressed", "application/zstd", "application/pdf",
+		"application/octet-stream":
+		return false
+	}
+	return true
+}
+
+// negotiateEncoding picks gzip or deflate out of Accept-Encoding by quality value, preferring gzip on a tie,
+// or "" if neither is acceptable, see RFC 9110, section 12.5.3.
+func negotiateEncoding(values []string) string {
+	var gzipQ, deflateQ, anyQ float64 = -1, -1, -1
+	for _, v := range values {
+		for _, elem := range strings.Split(v, ",") {
+			coding, q := elem, 1.0
+			if i := strings.IndexByte(elem, ';'); i >= 0 {
+				coding = elem[:i]
+				param := strings.TrimSpace(elem[i+1:])
+				if strings.HasPrefix(param, "q=") || strings.HasPrefix(param, "Q=") {
+					f, err := strconv.ParseFloat(param[2:], 64)
+					if err != nil {
+						continue
+					}
+					q = f
+				}
+			}
+			switch strings.ToLower(strings.TrimSpace(coding)) {
+			case "gzip", "x-gzip":
+				gzipQ = q
+			case "deflate":
+				deflateQ = q
+			case "*":
+				anyQ = q
+			}
+		}
+	}
+	if gzipQ < 0 {
+		gzipQ = anyQ
+	}
+	if deflateQ < 0 {
+		deflateQ = anyQ
+	}
+	switch {
+	case gzipQ > 0 && gzipQ >= deflateQ:
+		return "gzip"
+	case deflateQ > 0:
+		return "deflate"
+	}
+	return ""
+}
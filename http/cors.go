@@ -0,0 +1,156 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// corsPolicy is what cross-origin requests are allowed, see https://fetch.spec.whatwg.org/#http-cors-protocol.
+type corsPolicy struct {
+	// origins are the allowed origins, "*" allowing any.
+	origins []string
+	methods []string
+	// headers are the request headers allowed beyond the CORS-safelisted ones, those asked for by a preflight
+	// request are allowed if empty.
+	headers []string
+	// expose are the response headers scripts may read beyond the CORS-safelisted ones.
+	expose      []string
+	credentials bool
+	maxAge      time.Duration
+}
+
+func (p *corsPolicy) allowsOrigin(origin string) bool {
+	for _, o := range p.origins {
+		if o == "*" || o == origin {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *corsPolicy) allowsMethod(method string) bool {
+	// Simple methods are always allowed, see https://fetch.spec.whatwg.org/#cors-safelisted-method.
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost:
+		return true
+	}
+	for _, m := range p.methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *corsPolicy) allowsHeaders(requested string) bool {
+	if len(p.headers) == 0 {
+		return true
+	}
+	for _, h := range strings.Split(requested, ",") {
+		if h = strings.TrimSpace(h); h == "" {
+			continue
+		}
+		allowed := false
+		for _, a := range p.headers {
+			if strings.EqualFold(a, h) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	return true
+}
+
+// cors returns a middleware applying p to cross-origin requests: preflight requests are answered right away,
+// with a 204 if p allows the request they announce and a 403 otherwise, and the responses to the allowed
+// requests tell browsers they may be read. Requests from origins that aren't allowed go through untouched,
+// it's the browser that keeps their responses from the scripts that sent them.
+func cors(p *corsPolicy) Middleware {
+	methods := strings.Join(p.methods, ", ")
+	headers := strings.Join(p.headers, ", ")
+	expose := strings.Join(p.expose, ", ")
+	maxAge := strconv.Itoa(int(p.maxAge / time.Second))
+	return func(next Handler) Handler {
+		return HandlerFunc(func(w ResponseWriter, r *Request) {
+			origin := r.Header.Get("Origin")
+			h := w.Header()
+			// The response depends on the origin, unless any is allowed the same way.
+			if !(len(p.origins) == 1 && p.origins[0] == "*") {
+				h.Add("Vary", "Origin")
+			}
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			reqMethod := r.Header.Get("Access-Control-Request-Method")
+			if r.Method == http.MethodOptions && reqMethod != "" {
+				h.Add("Vary", "Access-Control-Request-Method")
+				h.Add("Vary", "Access-Control-Request-Headers")
+				reqHeaders := r.Header.Get("Access-Control-Request-Headers")
+				if !p.allowsOrigin(origin) || !p.allowsMethod(reqMethod) || !p.allowsHeaders(reqHeaders) {
+					http.Error(w, "403 forbidden: cross-origin request not allowed", http.StatusForbidden)
+					return
+				}
+				p.allowOrigin(h, origin)
+				h.Set("Access-Control-Allow-Methods", methods)
+				if headers != "" {
+					h.Set("Access-Control-Allow-Headers", headers)
+				} else if reqHeaders != "" {
+					h.Set("Access-Control-Allow-Headers", reqHeaders)
+				}
+				if p.maxAge > 0 {
+					h.Set("Access-Control-Max-Age", maxAge)
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			if p.allowsOrigin(origin) {
+				p.allowOrigin(h, origin)
+				if expose != "" {
+					h.Set("Access-Control-Expose-Headers", expose)
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// allowOrigin sets the headers allowing origin. Credentials are only allowed to an origin listed explicitly,
+// through "*" they would be allowed to any site.
+func (p *corsPolicy) allowOrigin(h http.Header, origin string) {
+	switch {
+	case p.credentials && p.lists(origin):
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Set("Access-Control-Allow-Credentials", "true")
+	case len(p.origins) == 1 && p.origins[0] == "*":
+		h.Set("Access-Control-Allow-Origin", "*")
+	default:
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+}
+
+// lists reports whether origin is one of the origins, not counting "*".
+func (p *corsPolicy) lists(origin string) bool {
+	for _, o := range p.origins {
+		if o == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// splitList splits a comma-separated list, dropping the blanks around and the empty elements.
+func splitList(s string) []string {
+	var elems []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			elems = append(elems, e)
+		}
+	}
+	return elems
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// dateCache holds the value of the Date header, which only changes once per second, so it's formatted
+// by the ticker instead of for every response. Event-loops load it without locking.
+type dateCache struct {
+	v atomic.Value
+}
+
+func (d *dateCache) update(now time.Time) {
+	d.v.Store(now.UTC().AppendFormat(nil, http.TimeFormat))
+}
+
+// get returns the current value, which must not be modified as it is shared by all event-loops.
+func (d *dateCache) get() []byte {
+	return d.v.Load().([]byte)
+}
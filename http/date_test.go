@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func BenchmarkAppendDate(b *testing.B) {
+	buf := make([]byte, 0, 64)
+	b.Run("cached", func(b *testing.B) {
+		var d dateCache
+		d.update(time.Now())
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			buf = append(buf[:0], d.get()...)
+		}
+	})
+	b.Run("format", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			buf = time.Now().UTC().AppendFormat(buf[:0], http.TimeFormat)
+		}
+	})
+}
+
+// BenchmarkPipelinedResponses serves batches of 16 pipelined requests, with the Date header taken from
+// the cache as the server does, and formatted anew for every response as it was before the cache.
+func BenchmarkPipelinedResponses(b *testing.B) {
+	const pipeline = 16
+	batch := bytes.Repeat([]byte("GET / HTTP/1.1\r\nHost: example.com\r\nUser-Agent: bench\r\n\r\n"), pipeline)
+	for _, perResponse := range []bool{false, true} {
+		name := "cached"
+		if perResponse {
+			name = "format"
+		}
+		b.Run(name, func(b *testing.B) {
+			hs := newTestServer(nil)
+			h := HandlerFunc(func(w ResponseWriter, r *Request) {
+				if perResponse {
+					hs.date.update(time.Now())
+				}
+				w.Header().Set("Content-Type", "text/plain")
+				_, _ = w.Write([]byte("Hello World!"))
+			})
+			hc := newHTTPCodec(testConn{}, hs)
+			b.ReportAllocs()
+			b.SetBytes(int64(len(batch)))
+			for i := 0; i < b.N; i++ {
+				if n, err := hc.decode(h, batch); n != len(batch) || err != nil {
+					b.Fatalf("decoded %d of %d bytes: %v", n, len(batch), err)
+				}
+				hc.buf = hc.buf[:0]
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// statusError is a malformed or unacceptable request, it is answered with status and the connection is closed.
+type statusError struct {
+	status int
+	msg    string
+}
+
+func (e *statusError) Error() string {
+	return e.msg
+}
+
+// errorStatus returns the status of the response to a request that failed to decode with err.
+func errorStatus(err error) int {
+	if se, ok := err.(*statusError); ok {
+		return se.status
+	}
+	return http.StatusBadRequest
+}
+
+// appendError appends a complete response with status and a plain text body to buf, it asks the client
+// to close the connection as the server is about to.
+func appendError(buf []byte, status int, date []byte) []byte {
+	text := http.StatusText(status)
+	buf = append(buf, "HTTP/1.1 "...)
+	buf = strconv.AppendInt(buf, int64(status), 10)
+	buf = append(buf, ' ')
+	buf = append(buf, text...)
+	buf = append(buf, "\r\nServer: gnet\r\nDate: "...)
+	buf = append(buf, date...)
+	buf = append(buf, "\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Length: "...)
+	buf = strconv.AppendInt(buf, int64(len(text)+5), 10)
+	buf = append(buf, "\r\nConnection: close\r\n\r\n"...)
+	buf = strconv.AppendInt(buf, int64(status), 10)
+	buf = append(buf, ' ')
+	buf = append(buf, text...)
+	return append(buf, '\n')
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/panjf2000/gnet/v2"
+)
+
+// startServer runs hs on a free local port until the end of the test and returns its address.
+func startServer(t *testing.T, hs *httpServer) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	_ = l.Close()
+	protoAddr := "tcp://" + addr
+	hs.addr = protoAddr
+	errs := make(chan error, 1)
+	go func() {
+		errs <- gnet.Run(hs, protoAddr, gnet.WithTicker(true))
+	}()
+	t.Cleanup(func() {
+		_ = gnet.Stop(context.Background(), protoAddr)
+		<-errs
+	})
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		c, err := net.Dial("tcp", addr)
+		if err == nil {
+			_ = c.Close()
+			return addr
+		}
+		select {
+		case err := <-errs:
+			t.Fatalf("server exits: %v", err)
+		default:
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server not listening: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestErrorResponses(t *testing.T) {
+	hs := newTestServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.Path == "/panic" {
+			panic("handler failure")
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	hs.maxBody = 1024
+	hs.requestTimeout = 100 * time.Millisecond
+	addr := startServer(t, hs)
+
+	tests := []struct {
+		name    string
+		request string
+		status  int
+	}{
+		{"malformed request line", "GET /\r\n\r\n", http.StatusBadRequest},
+		{"missing Host", "GET / HTTP/1.1\r\n\r\n", http.StatusBadRequest},
+		{"request smuggling", "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 3\r\nTransfer-Encoding: chunked\r\n\r\n", http.StatusBadRequest},
+		{"timeout", "GET / HTTP/1.1\r\nHost: x\r\n", http.StatusRequestTimeout},
+		{"body too large", "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 4096\r\n\r\n", http.StatusRequestEntityTooLarge},
+		{"chunked body too large", "POST / HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\n1000\r\n", http.StatusRequestEntityTooLarge},
+		{"request line too long", "GET /" + strings.Repeat("a", 9<<10) + " HTTP/1.1\r\nHost: x\r\n\r\n", http.StatusRequestURITooLong},
+		{"too many header fields", "GET / HTTP/1.1\r\nHost: x\r\n" + strings.Repeat("X-A: 1\r\n", 101) + "\r\n", http.StatusRequestHeaderFieldsTooLarge},
+		{"header too large", "GET / HTTP/1.1\r\nHost: x\r\nX-A: " + strings.Repeat("a", 65<<10) + "\r\n\r\n", http.StatusRequestHeaderFieldsTooLarge},
+		{"handler panic", "GET /panic HTTP/1.1\r\nHost: x\r\n\r\n", http.StatusInternalServerError},
+		{"unsupported version", "GET / HTTP/2.0\r\nHost: x\r\n\r\n", http.StatusHTTPVersionNotSupported},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			_ = c.SetDeadline(time.Now().Add(5 * time.Second))
+			if _, err = io.WriteString(c, tt.request); err != nil {
+				t.Fatal(err)
+			}
+			br := bufio.NewReader(c)
+			resp, err := http.ReadResponse(br, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = io.Copy(ioutil.Discard, resp.Body); err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.status || resp.Proto != "HTTP/1.1" {
+				t.Errorf("got status line %s %s, want HTTP/1.1 %d", resp.Proto, resp.Status, tt.status)
+			}
+			// ReadResponse takes Connection: close out of the header and reports it as Close.
+			if !resp.Close {
+				t.Errorf("no Connection: close in the response")
+			}
+			if n, err := br.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+				t.Errorf("connection still open after the response: read %d bytes, %v", n, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/panjf2000/gnet/v2"
+	"github.com/panjf2000/gnet/v2/pkg/pool/goroutine"
+)
+
+const (
+	// streamChunkSize is the size of the reads of a streamed file, smaller files are sent in one go.
+	streamChunkSize = 64 << 10
+	// maxStreamPending is how much of a streamed file may sit in the outbound buffer of a connection,
+	// the next chunk is read once the peer has caught up.
+	maxStreamPending = 4 * streamChunkSize
+)
+
+// fileServer serves the files under a root directory, it expects the file path in the "filepath" wildcard.
+type fileServer struct {
+	root string
+	pool *goroutine.Pool
+}
+
+// serveFiles returns a handler serving the directory tree at root, with conditional and range requests.
+// Files larger than streamChunkSize are streamed off the event-loop, chunk by chunk.
+func serveFiles(root string, pool *goroutine.Pool) Handler {
+	return &fileServer{root: root, pool: pool}
+}
+
+func (fs *fileServer) ServeHTTP(w ResponseWriter, r *Request) {
+	name := "/" + r.Params.ByName("filepath")
+	if containsDotDot(name) {
+		http.Error(w, "400 bad request: invalid URL path", http.StatusBadRequest)
+		return
+	}
+	name = path.Clean(name)
+	f, fi, err := fs.open(name)
+	if err != nil {
+		switch {
+		case os.IsNotExist(err):
+			http.Error(w, "404 page not found", http.StatusNotFound)
+		case os.IsPermission(err):
+			http.Error(w, "403 forbidden", http.StatusForbidden)
+		default:
+			http.Error(w, "500 internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	h := w.Header()
+	modtime := fi.ModTime().UTC()
+	etag := fmt.Sprintf(`"%x-%x"`, modtime.UnixNano(), fi.Size())
+	h.Set("ETag", etag)
+	h.Set("Last-Modified", modtime.Format(http.TimeFormat))
+	h.Set("Accept-Ranges", "bytes")
+	if notModified(r, etag, modtime) {
+		_ = f.Close()
+		delete(h, "Accept-Ranges")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	ctype := mime.TypeByExtension(filepath.Ext(fi.Name()))
+	if ctype == "" {
+		var sniff [512]byte
+		n, _ := io.ReadFull(f, sniff[:])
+		ctype = http.DetectContentType(sniff[:n])
+	}
+	h.Set("Content-Type", ctype)
+
+	status, offset, length := http.StatusOK, int64(0), fi.Size()
+	if rng := r.Header.Get("Range"); rng != "" && ifRangeMatches(r, etag, modtime) {
+		var ok bool
+		offset, length, ok = parseRange(rng, fi.Size())
+		if !ok {
+			_ = f.Close()
+			h.Set("Content-Range", fmt.Sprintf("bytes */%d", fi.Size()))
+			http.Error(w, "416 requested range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		if length != fi.Size() {
+			status = http.StatusPartialContent
+			h.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, fi.Size()))
+		}
+	}
+	h.Set("Content-Length", strconv.FormatInt(length, 10))
+
+	// Large files are streamed by a worker, unless the ResponseWriter, wrapped by some middleware, can't be handed over.
+	var rw asyncWriter
+	if d, ok := w.(detacher); ok && r.Method != http.MethodHead && length > streamChunkSize {
+		rw = d.detachWriter()
+	}
+	if rw == nil {
+		defer f.Close()
+		w.WriteHeader(status)
+		if r.Method != http.MethodHead {
+			_, _ = io.Copy(w, io.NewSectionReader(f, offset, length))
+		}
+		return
+	}
+
+	rw.WriteHeader(status)
+	hc := rw.codec()
+	err = fs.pool.Submit(func() {
+		defer f.Close()
+		if err := streamFile(rw, hc, io.NewSectionReader(f, offset, length)); err != nil {
+			// A reset HTTP/2 stream is over, the connection goes on with the other streams.
+			if err != errStreamReset {
+				log.Printf("failed to stream %s to %s: %v\n", name, r.RemoteAddr, err)
+				_ = hc.conn.Close(nil)
+			}
+			return
+		}
+		rw.finish()
+	})
+	if err != nil {
+		_ = f.Close()
+		rw.fail()
+	}
+}
+
+// open opens the file at name, which is slash-separated and clean, or the index.html of a directory.
+func (fs *fileServer) open(name string) (*os.File, os.FileInfo, error) {
+	p := filepath.Join(fs.root, filepath.FromSlash(name))
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	fi, err := f.Stat()
+	if err == nil && fi.IsDir() {
+		// Directory listings aren't served.
+		_ = f.Close()
+		if f, err = os.Open(filepath.Join(p, "index.html")); err != nil {
+			return nil, nil, err
+		}
+		fi, err = f.Stat()
+	}
+	if err != nil {
+		_ = f.Close()
+		return nil, nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		_ = f.Close()
+		return nil, nil, os.ErrNotExist
+	}
+	return f, fi, nil
+}
+
+var errConnClosed = errors.New("connection closed")
+
+// streamFile writes the content of rd to rw a chunk at a time, flushing each of them, and waits for
+// the backlog of the response to drain below maxStreamPending before reading more, so that a large file
+// doesn't end up buffered in memory in full. It gives up when the connection is closed, or the stream reset.
+func streamFile(rw asyncWriter, hc *httpCodec, rd io.Reader) error {
+	type backlog struct {
+		n   int
+		err error
+	}
+	pending := make(chan backlog, 1)
+	report := func(c gnet.Conn) error {
+		n, err := rw.backlog(c)
+		pending <- backlog{n, err}
+		return nil
+	}
+	wait := func() error {
+		for {
+			// Flushed chunks are queued on the event-loop ahead of this probe.
+			if err := hc.conn.AsyncWrite(nil, report); err != nil {
+				return err
+			}
+			select {
+			case b := <-pending:
+				if b.err != nil || b.n < maxStreamPending {
+					return b.err
+				}
+			case <-hc.closed:
+				return errConnClosed
+			}
+			// The peer is slow, look again in a moment.
+			time.Sleep(time.Millisecond)
+		}
+	}
+	chunk := make([]byte, streamChunkSize)
+	for {
+		n, err := io.ReadFull(rd, chunk)
+		if n > 0 {
+			_, _ = rw.Write(chunk[:n])
+			rw.Flush()
+			if err := wait(); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// containsDotDot reports whether p has a ".." element, such a path may escape the root directory.
+func containsDotDot(p string) bool {
+	if !strings.Contains(p, "..") {
+		return false
+	}
+	for _, elem := range strings.FieldsFunc(p, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if elem == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified evaluates If-None-Match, or If-Modified-Since in its absence, see RFC 9110, section 13.2.2.
+func notModified(r *Request, etag string, modtime time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagMatches(inm, etag)
+	}
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	return !modtime.Truncate(time.Second).After(ims)
+}
+
+// ifRangeMatches reports whether a Range request is to be honoured given its If-Range, if any.
+func ifRangeMatches(r *Request, etag string, modtime time.Time) bool {
+	ir := r.Header.Get("If-Range")
+	if ir == "" {
+		return true
+	}
+	if strings.HasPrefix(ir, `"`) || strings.HasPrefix(ir, "W/") {
+		return ir == etag
+	}
+	t, err := http.ParseTime(ir)
+	return err == nil && modtime.Truncate(time.Second).Equal(t)
+}
+
+// etagMatches reports whether etag is in the list of entity tags of an If-None-Match, weakly compared.
+func etagMatches(list, etag string) bool {
+	for _, t := range strings.Split(list, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRange parses a single byte range, "bytes=first-last", "bytes=first-" or "bytes=-suffix",
+// against a file of the given size. A malformed or multi-range header selects the whole file,
+// ok is false only for a range that can't be satisfied.
+func parseRange(s string, size int64) (offset, length int64, ok bool) {
+	const prefix = "bytes="
+	if !strings.HasPrefix(s, prefix) || strings.Contains(s, ",") {
+		return 0, size, true
+	}
+	spec := strings.TrimSpace(s[len(prefix):])
+	i := strings.IndexByte(spec, '-')
+	if i < 0 {
+		return 0, size, true
+	}
+	first, last := strings.TrimSpace(spec[:i]), strings.TrimSpace(spec[i+1:])
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, size, true
+		}
+		if n == 0 || size == 0 {
+			return 0, 0, false
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, true
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, size, true
+	}
+	if start >= size {
+		return 0, 0, false
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, size, true
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end - start + 1, true
+}
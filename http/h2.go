@@ -0,0 +1,855 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/panjf2000/gnet/v2"
+	"golang.org/x/net/http2/hpack"
+)
+
+const (
+	// h2MaxConcurrentStreams is how many streams a client may have open at once, more are refused.
+	h2MaxConcurrentStreams = 100
+	// h2WindowSize is the receive window of streams and of the connection, replenished as DATA arrives.
+	h2WindowSize = 1 << 20
+	// h2MaxHeaderBlock bounds the header blocks fed to the HPACK decoder when the server has no max header size.
+	h2MaxHeaderBlock = 1 << 20
+)
+
+// errStreamReset is the error of writing to a stream that has been reset.
+var errStreamReset = errors.New("stream reset")
+
+// h2Stream is a request and its response on an HTTP/2 connection.
+type h2Stream struct {
+	id  uint32
+	req *Request
+
+	// The request body is gathered in body, or written to the sink of the request, up to maxBody bytes.
+	body       []byte
+	received   int64
+	maxBody    int64
+	sinkOpen   bool
+	recvWindow int
+	endRecv    bool
+	// discard is set once the request has been answered early, by a 413, its DATA is ignored then.
+	discard bool
+
+	// Response DATA waiting for the flow-control windows to open.
+	sendWindow int64
+	pending    []byte
+	pendingEnd bool
+	sentEnd    bool
+	reset      bool
+}
+
+// h2Conn is the context of a connection speaking HTTP/2 with prior knowledge, h2c, see RFC 9113.
+// Streams are served by the same handlers as HTTP/1.1 requests, concurrently: a detached response
+// no longer holds the requests that come after it. Frames are only written on the event-loop.
+type h2Conn struct {
+	srv *httpServer
+	// hc is the HTTP/1.1 codec the connection started with, it keeps the idle time and the closed channel.
+	hc  *httpCodec
+	c   gnet.Conn
+	out []byte
+
+	dec    *hpack.Decoder
+	enc    *hpack.Encoder
+	encBuf bytes.Buffer
+
+	prefaceDone  bool
+	settingsSeen bool
+	streams      map[uint32]*h2Stream
+	lastStream   uint32
+	goingAway    bool
+
+	// What the client has set up, and the connection windows.
+	maxFrameSize  int
+	initialWindow int64
+	sendWindow    int64
+	recvWindow    int
+
+	// A header block being received over CONTINUATION frames.
+	cont          *h2Stream
+	contStream    uint32
+	contEndStream bool
+	headerBlock   []byte
+}
+
+func newH2Conn(hc *httpCodec) *h2Conn {
+	h2 := &h2Conn{
+		srv:           hc.srv,
+		hc:            hc,
+		c:             hc.conn,
+		dec:           hpack.NewDecoder(4096, nil),
+		streams:       make(map[uint32]*h2Stream),
+		maxFrameSize:  defaultMaxFrameSize,
+		initialWindow: defaultWindowSize,
+		sendWindow:    defaultWindowSize,
+		recvWindow:    h2WindowSize,
+	}
+	h2.enc = hpack.NewEncoder(&h2.encBuf)
+	if hc.srv.maxHeaderBytes > 0 {
+		h2.dec.SetMaxStringLength(hc.srv.maxHeaderBytes)
+	}
+	// The server preface, see RFC 9113, section 3.4.
+	h2.out = appendSettings(h2.out,
+		settingMaxConcurrentStreams, h2MaxConcurrentStreams,
+		settingInitialWindowSize, h2WindowSize,
+		settingEnablePush, 0)
+	h2.out = appendWindowUpdate(h2.out, 0, h2WindowSize-defaultWindowSize)
+	return h2
+}
+
+// flush writes the frames produced so far, it must be called on the event-loop.
+func (h2 *h2Conn) flush() {
+	if len(h2.out) > 0 {
+		_, _ = h2.c.Write(h2.out)
+		h2.out = h2.out[:0]
+	}
+}
+
+// touch records since when the connection has had no stream open, for the idle timeout.
+func (h2 *h2Conn) touch() {
+	var since int64
+	if len(h2.streams) == 0 {
+		since = time.Now().UnixNano()
+	}
+	atomic.StoreInt64(&h2.hc.idleSince, since)
+}
+
+// done reports whether a client that sent a GOAWAY has got all its responses, the connection can be closed then.
+func (h2 *h2Conn) done() bool {
+	return h2.goingAway && len(h2.streams) == 0
+}
+
+// decode handles every complete frame at the start of buf and returns how many bytes it consumed,
+// an *h2ConnError means the connection is to be closed after a GOAWAY.
+func (h2 *h2Conn) decode(buf []byte) (consumed int, err error) {
+	if !h2.prefaceDone {
+		if len(buf) < len(h2Preface) {
+			return 0, nil
+		}
+		if !bytes.HasPrefix(buf, h2Preface) {
+			return 0, &h2ConnError{errCodeProtocol, "invalid connection preface"}
+		}
+		consumed, h2.prefaceDone = len(h2Preface), true
+	}
+	for len(buf)-consumed >= frameHeaderLen {
+		fh := parseFrameHeader(buf[consumed:])
+		if fh.length > defaultMaxFrameSize {
+			return consumed, &h2ConnError{errCodeFrameSize, "frame too large"}
+		}
+		end := consumed + frameHeaderLen + fh.length
+		if end > len(buf) {
+			break
+		}
+		payload := buf[consumed+frameHeaderLen : end]
+		consumed = end
+		if err := h2.handleFrame(fh, payload); err != nil {
+			se, ok := err.(*h2StreamError)
+			if !ok {
+				return consumed, err
+			}
+			h2.out = appendRSTStream(h2.out, se.stream, se.code)
+			if st := h2.streams[se.stream]; st != nil {
+				h2.abortBody(st, errBodyAborted)
+				st.reset = true
+				delete(h2.streams, se.stream)
+			}
+		}
+	}
+	return consumed, nil
+}
+
+func (h2 *h2Conn) handleFrame(fh frameHeader, p []byte) error {
+	if h2.contStream != 0 && (fh.typ != frameContinuation || fh.stream != h2.contStream) {
+		return &h2ConnError{errCodeProtocol, "expected CONTINUATION frame"}
+	}
+	if !h2.settingsSeen {
+		if fh.typ != frameSettings || fh.flags&flagAck != 0 {
+			return &h2ConnError{errCodeProtocol, "expected SETTINGS frame"}
+		}
+		h2.settingsSeen = true
+	}
+	switch fh.typ {
+	case frameData:
+		return h2.onData(fh, p)
+	case frameHeaders:
+		return h2.onHeaders(fh, p)
+	case framePriority:
+		// Priorities are advisory and ignored.
+		if fh.stream == 0 {
+			return &h2ConnError{errCodeProtocol, "PRIORITY on stream 0"}
+		}
+		if len(p) != 5 {
+			return &h2StreamError{fh.stream, errCodeFrameSize}
+		}
+	case frameRSTStream:
+		if fh.stream == 0 || fh.stream > h2.lastStream {
+			return &h2ConnError{errCodeProtocol, "RST_STREAM on an idle stream"}
+		}
+		if len(p) != 4 {
+			return &h2ConnError{errCodeFrameSize, "invalid RST_STREAM"}
+		}
+		if st := h2.streams[fh.stream]; st != nil {
+			h2.abortBody(st, errBodyAborted)
+			st.reset = true
+			delete(h2.streams, fh.stream)
+		}
+	case frameSettings:
+		return h2.onSettings(fh, p)
+	case framePushPromise:
+		return &h2ConnError{errCodeProtocol, "clients can't push"}
+	case framePing:
+		if fh.stream != 0 {
+			return &h2ConnError{errCodeProtocol, "PING on a stream"}
+		}
+		if len(p) != 8 {
+			return &h2ConnError{errCodeFrameSize, "invalid PING"}
+		}
+		if fh.flags&flagAck == 0 {
+			h2.out = appendFrameHeader(h2.out, 8, framePing, flagAck, 0)
+			h2.out = append(h2.out, p...)
+		}
+	case frameGoAway:
+		if fh.stream != 0 {
+			return &h2ConnError{errCodeProtocol, "GOAWAY on a stream"}
+		}
+		h2.goingAway = true
+	case frameWindowUpdate:
+		return h2.onWindowUpdate(fh, p)
+	case frameContinuation:
+		if h2.contStream == 0 {
+			return &h2ConnError{errCodeProtocol, "unexpected CONTINUATION frame"}
+		}
+		return h2.appendHeaderBlock(fh, p)
+	}
+	// Frames of unknown types are ignored, see RFC 9113, section 5.5.
+	return nil
+}
+
+func (h2 *h2Conn) onSettings(fh frameHeader, p []byte) error {
+	if fh.stream != 0 {
+		return &h2ConnError{errCodeProtocol, "SETTINGS on a stream"}
+	}
+	if fh.flags&flagAck != 0 {
+		if len(p) != 0 {
+			return &h2ConnError{errCodeFrameSize, "SETTINGS ack with a payload"}
+		}
+		return nil
+	}
+	if len(p)%6 != 0 {
+		return &h2ConnError{errCodeFrameSize, "invalid SETTINGS"}
+	}
+	for ; len(p) > 0; p = p[6:] {
+		id, v := binary.BigEndian.Uint16(p), binary.BigEndian.Uint32(p[2:])
+		switch id {
+		case settingHeaderTableSize:
+			h2.enc.SetMaxDynamicTableSizeLimit(v)
+		case settingEnablePush:
+			if v > 1 {
+				return &h2ConnError{errCodeProtocol, "invalid SETTINGS_ENABLE_PUSH"}
+			}
+		case settingInitialWindowSize:
+			if v > maxWindowSize {
+				return &h2ConnError{errCodeFlowControl, "invalid SETTINGS_INITIAL_WINDOW_SIZE"}
+			}
+			// The change applies to the windows of the open streams, see RFC 9113, section 6.9.2.
+			delta := int64(v) - h2.initialWindow
+			h2.initialWindow = int64(v)
+			for _, st := range h2.streams {
+				st.sendWindow += delta
+			}
+		case settingMaxFrameSize:
+			if v < defaultMaxFrameSize || v > maxFrameSizeLimit {
+				return &h2ConnError{errCodeProtocol, "invalid SETTINGS_MAX_FRAME_SIZE"}
+			}
+			h2.maxFrameSize = int(v)
+		}
+	}
+	h2.out = appendFrameHeader(h2.out, 0, frameSettings, flagAck, 0)
+	for _, st := range h2.streams {
+		h2.sendPending(st)
+	}
+	return nil
+}
+
+func (h2 *h2Conn) onWindowUpdate(fh frameHeader, p []byte) error {
+	if len(p) != 4 {
+		return &h2ConnError{errCodeFrameSize, "invalid WINDOW_UPDATE"}
+	}
+	incr := int64(binary.BigEndian.Uint32(p) & (1<<31 - 1))
+	if fh.stream == 0 {
+		if incr == 0 {
+			return &h2ConnError{errCodeProtocol, "zero WINDOW_UPDATE increment"}
+		}
+		if h2.sendWindow += incr; h2.sendWindow > maxWindowSize {
+			return &h2ConnError{errCodeFlowControl, "connection window overflow"}
+		}
+		for _, st := range h2.streams {
+			h2.sendPending(st)
+		}
+		return nil
+	}
+	st := h2.streams[fh.stream]
+	if st == nil {
+		if fh.stream > h2.lastStream {
+			return &h2ConnError{errCodeProtocol, "WINDOW_UPDATE on an idle stream"}
+		}
+		return nil
+	}
+	if incr == 0 {
+		return &h2StreamError{fh.stream, errCodeProtocol}
+	}
+	if st.sendWindow += incr; st.sendWindow > maxWindowSize {
+		return &h2StreamError{fh.stream, errCodeFlowControl}
+	}
+	h2.sendPending(st)
+	return nil
+}
+
+func (h2 *h2Conn) onHeaders(fh frameHeader, p []byte) error {
+	id := fh.stream
+	if id == 0 {
+		return &h2ConnError{errCodeProtocol, "HEADERS on stream 0"}
+	}
+	p, err := stripPadding(fh, p)
+	if err != nil {
+		return err
+	}
+	if fh.flags&flagPriority != 0 {
+		if len(p) < 5 {
+			return &h2ConnError{errCodeFrameSize, "invalid HEADERS"}
+		}
+		p = p[5:]
+	}
+	st := h2.streams[id]
+	switch {
+	case st == nil && id > h2.lastStream:
+		// Client streams are odd-numbered and opened in order, see RFC 9113, section 5.1.1.
+		if id%2 == 0 {
+			return &h2ConnError{errCodeProtocol, "invalid stream identifier"}
+		}
+		h2.lastStream = id
+		st = &h2Stream{id: id, recvWindow: h2WindowSize, sendWindow: h2.initialWindow}
+		h2.streams[id] = st
+	case st == nil:
+		// Trailers of a stream that has been reset, the block is still decoded to keep the HPACK state in sync.
+		st = &h2Stream{id: id, reset: true}
+	case st.endRecv:
+		return &h2ConnError{errCodeStreamClosed, "HEADERS on a half-closed stream"}
+	}
+	h2.cont, h2.contStream, h2.contEndStream = st, id, fh.flags&flagEndStream != 0
+	h2.headerBlock = h2.headerBlock[:0]
+	return h2.appendHeaderBlock(fh, p)
+}
+
+// appendHeaderBlock gathers a header block fragment, the block is decoded once complete.
+func (h2 *h2Conn) appendHeaderBlock(fh frameHeader, p []byte) error {
+	h2.headerBlock = append(h2.headerBlock, p...)
+	max := 2 * h2.srv.maxHeaderBytes
+	if max <= 0 {
+		max = h2MaxHeaderBlock
+	}
+	if len(h2.headerBlock) > max {
+		return &h2ConnError{errCodeEnhanceYourCalm, "header block too large"}
+	}
+	if fh.flags&flagEndHeaders == 0 {
+		return nil
+	}
+	st := h2.cont
+	id := st.id
+	h2.cont, h2.contStream = nil, 0
+	// Every block is decoded, even those of streams about to be refused, to keep the HPACK state in sync.
+	fields, err := h2.dec.DecodeFull(h2.headerBlock)
+	if err != nil {
+		return &h2ConnError{errCodeCompression, err.Error()}
+	}
+	if st.reset {
+		return nil
+	}
+	st.endRecv = h2.contEndStream
+	if st.req != nil {
+		// Trailers end the stream, see RFC 9113, section 8.1.
+		if !h2.contEndStream {
+			return &h2StreamError{id, errCodeProtocol}
+		}
+		for _, f := range fields {
+			if strings.HasPrefix(f.Name, ":") || !validH2Field(f) {
+				return &h2StreamError{id, errCodeProtocol}
+			}
+			name := http.CanonicalHeaderKey(f.Name)
+			if forbiddenTrailers[name] {
+				continue
+			}
+			if st.req.Trailer == nil {
+				st.req.Trailer = make(http.Header)
+			}
+			st.req.Trailer[name] = append(st.req.Trailer[name], f.Value)
+		}
+	} else {
+		if len(h2.streams) > h2MaxConcurrentStreams {
+			return &h2StreamError{id, errCodeRefusedStream}
+		}
+		req, status, err := h2.newRequest(id, fields)
+		if err != nil {
+			return err
+		}
+		st.req = req
+		if status != 0 {
+			h2.reject(st, status)
+		} else if !st.endRecv {
+			h2.openBody(st)
+		}
+	}
+	if st.endRecv {
+		return h2.endStream(st)
+	}
+	return nil
+}
+
+// validH2Field reports whether f is a valid regular field: its name is a lowercase token and its value has
+// no control characters, nor whitespace around it, see RFC 9113, section 8.2.1.
+func validH2Field(f hpack.HeaderField) bool {
+	return f.Name == strings.ToLower(f.Name) && isToken([]byte(f.Name)) &&
+		isFieldValue([]byte(f.Value)) && strings.Trim(f.Value, " \t") == f.Value
+}
+
+// newRequest builds the Request of a header block, it returns a status other than 0 if the request
+// is to be rejected, and a stream error if it's malformed, see RFC 9113, section 8.1.1.
+func (h2 *h2Conn) newRequest(id uint32, fields []hpack.HeaderField) (*Request, int, error) {
+	malformed := &h2StreamError{id, errCodeProtocol}
+	r := &Request{Proto: "HTTP/2.0", Header: make(http.Header), RemoteAddr: h2.c.RemoteAddr().String()}
+	var scheme string
+	var status, size int
+	regular := false
+	for _, f := range fields {
+		size += len(f.Name) + len(f.Value) + 32
+		if strings.HasPrefix(f.Name, ":") {
+			// Pseudo-header fields come first, once each.
+			if regular {
+				return nil, 0, malformed
+			}
+			var v *string
+			switch f.Name {
+			case ":method":
+				v = &r.Method
+			case ":path":
+				v = &r.RequestURI
+			case ":scheme":
+				v = &scheme
+			case ":authority":
+				v = &r.Host
+			default:
+				return nil, 0, malformed
+			}
+			if *v != "" || f.Value == "" {
+				return nil, 0, malformed
+			}
+			*v = f.Value
+			continue
+		}
+		regular = true
+		if !validH2Field(f) {
+			return nil, 0, malformed
+		}
+		switch f.Name {
+		case "connection", "keep-alive", "proxy-connection", "transfer-encoding", "upgrade":
+			return nil, 0, malformed
+		case "te":
+			if f.Value != "trailers" {
+				return nil, 0, malformed
+			}
+		}
+		if max := h2.srv.maxHeaderCount; max > 0 && len(r.Header) >= max {
+			status = http.StatusRequestHeaderFieldsTooLarge
+		}
+		r.Header.Add(f.Name, f.Value)
+	}
+	if r.Method == "" || scheme == "" || r.RequestURI == "" || r.Method == http.MethodConnect {
+		return nil, 0, malformed
+	}
+	if max := h2.srv.maxHeaderBytes; max > 0 && size > max {
+		status = http.StatusRequestHeaderFieldsTooLarge
+	}
+	if r.Host == "" {
+		r.Host = r.Header.Get("Host")
+	}
+	r.Path, r.RawQuery = splitTarget(r.RequestURI)
+	return r, status, nil
+}
+
+func (h2 *h2Conn) onData(fh frameHeader, p []byte) error {
+	id := fh.stream
+	if id == 0 {
+		return &h2ConnError{errCodeProtocol, "DATA on stream 0"}
+	}
+	// Flow control counts the whole payload, padding included, and the connection window is replenished right away.
+	if fh.length > h2.recvWindow {
+		return &h2ConnError{errCodeFlowControl, "connection window exceeded"}
+	}
+	if fh.length > 0 {
+		h2.out = appendWindowUpdate(h2.out, 0, fh.length)
+	}
+	st := h2.streams[id]
+	if st == nil || st.req == nil {
+		if id > h2.lastStream {
+			return &h2ConnError{errCodeProtocol, "DATA on an idle stream"}
+		}
+		// The stream has been reset, DATA that was in flight meanwhile is dropped.
+		return nil
+	}
+	if st.endRecv {
+		return &h2StreamError{id, errCodeStreamClosed}
+	}
+	if fh.length > st.recvWindow {
+		return &h2StreamError{id, errCodeFlowControl}
+	}
+	data, err := stripPadding(fh, p)
+	if err != nil {
+		return err
+	}
+	st.endRecv = fh.flags&flagEndStream != 0
+	if !st.discard {
+		st.received += int64(len(data))
+		switch {
+		case st.maxBody > 0 && st.received > st.maxBody:
+			h2.abortBody(st, errBodyTooLarge)
+			h2.reject(st, http.StatusRequestEntityTooLarge)
+		case st.sinkOpen:
+			if _, err := st.req.Sink.Write(data); err != nil {
+				err = sinkError(err)
+				h2.abortBody(st, err)
+				h2.reject(st, errorStatus(err))
+			}
+		default:
+			st.body = append(st.body, data...)
+		}
+	}
+	if st.endRecv {
+		return h2.endStream(st)
+	}
+	if fh.length > 0 {
+		h2.out = appendWindowUpdate(h2.out, id, fh.length)
+	}
+	return nil
+}
+
+// endStream serves the request of st once it has been fully received.
+func (h2 *h2Conn) endStream(st *h2Stream) error {
+	if st.discard {
+		if st.sentEnd {
+			delete(h2.streams, st.id)
+		}
+		return nil
+	}
+	if cl := st.req.Header.Get("Content-Length"); cl != "" && cl != strconv.FormatInt(st.received, 10) {
+		return &h2StreamError{st.id, errCodeProtocol}
+	}
+	st.sinkOpen = false
+	st.req.Body = st.body
+	st.req.ContentLength = st.received
+	h2.serve(st)
+	return nil
+}
+
+// openBody prepares for the body of st to be received, the request having just been decoded.
+func (h2 *h2Conn) openBody(st *h2Stream) {
+	maxBody, err := h2.srv.openBody(h2.srv.bodyRoute(st.req.Method, st.req.Path), st.req)
+	if err != nil {
+		h2.reject(st, errorStatus(err))
+		return
+	}
+	st.maxBody, st.sinkOpen = maxBody, st.req.Sink != nil
+	if cl, err := strconv.ParseInt(st.req.Header.Get("Content-Length"), 10, 64); err == nil && maxBody > 0 && cl > maxBody {
+		h2.abortBody(st, errBodyTooLarge)
+		h2.reject(st, http.StatusRequestEntityTooLarge)
+	}
+}
+
+// abortBody tells the sink of the body of st, if it's still being written, why it won't be complete.
+func (h2 *h2Conn) abortBody(st *h2Stream, err error) {
+	if st.sinkOpen {
+		st.sinkOpen = false
+		st.req.Sink.Abort(err)
+	}
+}
+
+// reject answers the request of st with status before it has been fully received, the rest of it is discarded.
+func (h2 *h2Conn) reject(st *h2Stream, status int) {
+	st.discard = true
+	text := strconv.Itoa(status) + " " + strings.ToLower(http.StatusText(status)) + "\n"
+	header := http.Header{"Content-Type": {"text/plain; charset=utf-8"}, "Content-Length": {strconv.Itoa(len(text))}}
+	h2.writeHeaders(st, status, header, false)
+	h2.writeData(st, []byte(text), true)
+	if !st.endRecv && !st.reset {
+		// The client may stop sending, see RFC 9113, section 8.1.
+		h2.out = appendRSTStream(h2.out, st.id, errCodeNo)
+		st.reset = true
+		delete(h2.streams, st.id)
+	}
+}
+
+func (h2 *h2Conn) serve(st *h2Stream) {
+	w := &h2Response{h2: h2, st: st, req: st.req, header: make(http.Header)}
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("panic serving %s %s: %v\n", st.req.Method, st.req.RequestURI, p)
+			w.fail()
+		}
+	}()
+	h2.srv.handler.ServeHTTP(w, st.req)
+	w.finish()
+}
+
+// writeHeaders encodes a response head into HEADERS and CONTINUATION frames.
+func (h2 *h2Conn) writeHeaders(st *h2Stream, status int, header http.Header, endStream bool) {
+	if st.reset {
+		return
+	}
+	h2.encBuf.Reset()
+	_ = h2.enc.WriteField(hpack.HeaderField{Name: ":status", Value: strconv.Itoa(status)})
+	_ = h2.enc.WriteField(hpack.HeaderField{Name: "server", Value: "gnet"})
+	_ = h2.enc.WriteField(hpack.HeaderField{Name: "date", Value: string(h2.srv.date.get())})
+	for key, values := range header {
+		switch key {
+		// Connection-specific fields don't exist in HTTP/2.
+		case "Connection", "Keep-Alive", "Proxy-Connection", "Transfer-Encoding", "Upgrade":
+			continue
+		}
+		name := strings.ToLower(key)
+		for _, v := range values {
+			_ = h2.enc.WriteField(hpack.HeaderField{Name: name, Value: v})
+		}
+	}
+	block := h2.encBuf.Bytes()
+	typ, flags := byte(frameHeaders), byte(0)
+	if endStream {
+		flags |= flagEndStream
+	}
+	for {
+		n := len(block)
+		if n > h2.maxFrameSize {
+			n = h2.maxFrameSize
+		}
+		if n == len(block) {
+			flags |= flagEndHeaders
+		}
+		h2.out = appendFrameHeader(h2.out, n, typ, flags, st.id)
+		h2.out = append(h2.out, block[:n]...)
+		if block = block[n:]; len(block) == 0 {
+			break
+		}
+		typ, flags = frameContinuation, 0
+	}
+	if endStream {
+		h2.sent(st)
+	}
+}
+
+// writeData queues response body bytes, they go out as DATA frames as far as the flow-control windows allow.
+func (h2 *h2Conn) writeData(st *h2Stream, data []byte, endStream bool) {
+	if st.reset {
+		return
+	}
+	st.pending = append(st.pending, data...)
+	st.pendingEnd = st.pendingEnd || endStream
+	h2.sendPending(st)
+}
+
+func (h2 *h2Conn) sendPending(st *h2Stream) {
+	for !st.reset && !st.sentEnd && (len(st.pending) > 0 || st.pendingEnd) {
+		n := int64(len(st.pending))
+		if n > int64(h2.maxFrameSize) {
+			n = int64(h2.maxFrameSize)
+		}
+		if n > st.sendWindow {
+			n = st.sendWindow
+		}
+		if n > h2.sendWindow {
+			n = h2.sendWindow
+		}
+		if n <= 0 && len(st.pending) > 0 {
+			// Blocked until a WINDOW_UPDATE.
+			return
+		}
+		if n < 0 {
+			n = 0
+		}
+		var flags byte
+		last := int(n) == len(st.pending) && st.pendingEnd
+		if last {
+			flags = flagEndStream
+		}
+		h2.out = appendFrameHeader(h2.out, int(n), frameData, flags, st.id)
+		h2.out = append(h2.out, st.pending[:n]...)
+		st.sendWindow -= n
+		h2.sendWindow -= n
+		st.pending = st.pending[:copy(st.pending, st.pending[n:])]
+		if last {
+			h2.sent(st)
+		}
+	}
+}
+
+// sent marks the response of st as complete, the stream is closed once its request is complete too.
+func (h2 *h2Conn) sent(st *h2Stream) {
+	st.sentEnd, st.pendingEnd = true, false
+	if st.endRecv {
+		delete(h2.streams, st.id)
+	}
+}
+
+// h2Response is the ResponseWriter of a stream, it can be detached like its HTTP/1.1 counterpart,
+// the frames of a detached response are then produced on the event-loop, through AsyncWrite.
+type h2Response struct {
+	h2     *h2Conn
+	st     *h2Stream
+	req    *Request
+	header http.Header
+	status int
+	body   []byte
+
+	wroteHeader bool
+	detached    bool
+	async       bool
+}
+
+func (w *h2Response) Header() http.Header {
+	return w.header
+}
+
+func (w *h2Response) WriteHeader(statusCode int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = statusCode
+}
+
+func (w *h2Response) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !bodyAllowed(w.status) {
+		return 0, http.ErrBodyNotAllowed
+	}
+	w.body = append(w.body, p...)
+	return len(p), nil
+}
+
+// Flush sends the headers and whatever body has been written so far, without ending the stream.
+func (w *h2Response) Flush() {
+	w.emit(false)
+}
+
+func (w *h2Response) finish() {
+	if w.detached {
+		return
+	}
+	if !w.wroteHeader && bodyAllowed(w.statusCode()) && w.header.Get("Content-Length") == "" {
+		w.header.Set("Content-Length", strconv.Itoa(len(w.body)))
+	}
+	w.emit(true)
+}
+
+// fail completes the response of a handler that panicked: with a 500 if nothing has been sent yet,
+// by resetting the stream otherwise.
+func (w *h2Response) fail() {
+	if w.wroteHeader {
+		h2, st := w.h2, w.st
+		reset := func(gnet.Conn) error {
+			if !st.reset {
+				h2.out = appendRSTStream(h2.out, st.id, errCodeInternal)
+				st.reset = true
+				delete(h2.streams, st.id)
+				h2.flush()
+			}
+			return nil
+		}
+		if w.async {
+			_ = h2.c.AsyncWrite(nil, reset)
+		} else {
+			_ = reset(nil)
+		}
+		return
+	}
+	for k := range w.header {
+		delete(w.header, k)
+	}
+	w.status, w.body = 0, w.body[:0]
+	http.Error(w, "500 internal server error", http.StatusInternalServerError)
+	w.finish()
+}
+
+func (w *h2Response) detachWriter() asyncWriter {
+	w.detached = true
+	return &h2Response{h2: w.h2, st: w.st, req: w.req, header: w.header.Clone(), status: w.status, async: true}
+}
+
+func (w *h2Response) codec() *httpCodec {
+	return w.h2.hc
+}
+
+// backlog counts the DATA held back by flow control along with the outbound buffer of the connection,
+// a client that doesn't open its window would get the whole body buffered otherwise.
+func (w *h2Response) backlog(c gnet.Conn) (int, error) {
+	if w.st.reset {
+		return 0, errStreamReset
+	}
+	return c.OutboundBuffered() + len(w.st.pending), nil
+}
+
+func (w *h2Response) statusCode() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// emit turns what has been written so far into frames, on the event-loop of the connection.
+func (w *h2Response) emit(endStream bool) {
+	var header http.Header
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		header = w.header
+		if w.async {
+			header = w.header.Clone()
+		}
+	}
+	status, body := w.statusCode(), w.body
+	if w.req.Method == http.MethodHead {
+		body = nil
+	}
+	h2, st := w.h2, w.st
+	respond := func() {
+		if header != nil {
+			h2.writeHeaders(st, status, header, endStream && len(body) == 0)
+		}
+		if len(body) > 0 || endStream && header == nil {
+			h2.writeData(st, body, endStream)
+		}
+	}
+	if !w.async {
+		respond()
+		w.body = w.body[:0]
+		return
+	}
+	w.body = nil
+	_ = h2.c.AsyncWrite(nil, func(c gnet.Conn) error {
+		respond()
+		h2.flush()
+		h2.touch()
+		if h2.done() {
+			return c.Close(nil)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/binary"
+	"strconv"
+)
+
+// h2Preface is sent by HTTP/2 clients ahead of any frame, see RFC 9113, section 3.4.
+var h2Preface = []byte("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n")
+
+// Frame types, see RFC 9113, section 6.
+const (
+	frameData         = 0x0
+	frameHeaders      = 0x1
+	framePriority     = 0x2
+	frameRSTStream    = 0x3
+	frameSettings     = 0x4
+	framePushPromise  = 0x5
+	framePing         = 0x6
+	frameGoAway       = 0x7
+	frameWindowUpdate = 0x8
+	frameContinuation = 0x9
+)
+
+// Frame flags, ACK only applies to SETTINGS and PING.
+const (
+	flagEndStream  = 0x1
+	flagAck        = 0x1
+	flagEndHeaders = 0x4
+	flagPadded     = 0x8
+	flagPriority   = 0x20
+)
+
+// Settings, see RFC 9113, section 6.5.2.
+const (
+	settingHeaderTableSize      = 0x1
+	settingEnablePush           = 0x2
+	settingMaxConcurrentStreams = 0x3
+	settingInitialWindowSize    = 0x4
+	settingMaxFrameSize         = 0x5
+	settingMaxHeaderListSize    = 0x6
+)
+
+const (
+	frameHeaderLen      = 9
+	defaultMaxFrameSize = 16 << 10
+	maxFrameSizeLimit   = 1<<24 - 1
+	defaultWindowSize   = 65535
+	maxWindowSize       = 1<<31 - 1
+)
+
+// h2ErrCode is the error code of a RST_STREAM or GOAWAY frame, see RFC 9113, section 7.
+type h2ErrCode uint32
+
+const (
+	errCodeNo              h2ErrCode = 0x0
+	errCodeProtocol        h2ErrCode = 0x1
+	errCodeInternal        h2ErrCode = 0x2
+	errCodeFlowControl     h2ErrCode = 0x3
+	errCodeStreamClosed    h2ErrCode = 0x5
+	errCodeFrameSize       h2ErrCode = 0x6
+	errCodeRefusedStream   h2ErrCode = 0x7
+	errCodeCompression     h2ErrCode = 0x9
+	errCodeEnhanceYourCalm h2ErrCode = 0xb
+)
+
+// h2ConnError is a connection error, answered with a GOAWAY before closing the connection.
+type h2ConnError struct {
+	code   h2ErrCode
+	reason string
+}
+
+func (e *h2ConnError) Error() string {
+	return "http2: connection error " + strconv.Itoa(int(e.code)) + ": " + e.reason
+}
+
+// h2StreamError is a stream error, answered with a RST_STREAM, the connection goes on.
+type h2StreamError struct {
+	stream uint32
+	code   h2ErrCode
+}
+
+func (e *h2StreamError) Error() string {
+	return "http2: stream error " + strconv.Itoa(int(e.code)) + " on stream " + strconv.FormatUint(uint64(e.stream), 10)
+}
+
+type frameHeader struct {
+	length int
+	typ    byte
+	flags  byte
+	stream uint32
+}
+
+func parseFrameHeader(b []byte) frameHeader {
+	return frameHeader{
+		length: int(b[0])<<16 | int(b[1])<<8 | int(b[2]),
+		typ:    b[3],
+		flags:  b[4],
+		stream: binary.BigEndian.Uint32(b[5:]) & (1<<31 - 1),
+	}
+}
+
+func appendFrameHeader(buf []byte, length int, typ, flags byte, stream uint32) []byte {
+	buf = append(buf, byte(length>>16), byte(length>>8), byte(length), typ, flags)
+	return append(buf, byte(stream>>24), byte(stream>>16), byte(stream>>8), byte(stream))
+}
+
+func appendUint32(buf []byte, v uint32) []byte {
+	return append(buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// appendSettings appends a SETTINGS frame, settings being pairs of identifier and value.
+func appendSettings(buf []byte, settings ...uint32) []byte {
+	buf = appendFrameHeader(buf, len(settings)/2*6, frameSettings, 0, 0)
+	for i := 0; i+1 < len(settings); i += 2 {
+		buf = append(buf, byte(settings[i]>>8), byte(settings[i]))
+		buf = appendUint32(buf, settings[i+1])
+	}
+	return buf
+}
+
+func appendWindowUpdate(buf []byte, stream uint32, increment int) []byte {
+	buf = appendFrameHeader(buf, 4, frameWindowUpdate, 0, stream)
+	return appendUint32(buf, uint32(increment))
+}
+
+func appendRSTStream(buf []byte, stream uint32, code h2ErrCode) []byte {
+	buf = appendFrameHeader(buf, 4, frameRSTStream, 0, stream)
+	return appendUint32(buf, uint32(code))
+}
+
+func appendGoAway(buf []byte, lastStream uint32, code h2ErrCode, debug string) []byte {
+	buf = appendFrameHeader(buf, 8+len(debug), frameGoAway, 0, 0)
+	buf = appendUint32(buf, lastStream)
+	buf = appendUint32(buf, uint32(code))
+	return append(buf, debug...)
+}
+
+// stripPadding removes the padding of a DATA, HEADERS or PUSH_PROMISE frame, see RFC 9113, section 6.1.
+func stripPadding(fh frameHeader, p []byte) ([]byte, error) {
+	if fh.flags&flagPadded == 0 {
+		return p, nil
+	}
+	if len(p) == 0 || int(p[0]) >= len(p) {
+		return nil, &h2ConnError{errCodeProtocol, "invalid padding"}
+	}
+	return p[1 : len(p)-int(p[0])], nil
+}
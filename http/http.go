@@ -1,37 +1,63 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/evanphx/wildcat"
+	"github.com/gobwas/ws"
 	"github.com/panjf2000/gnet/v2"
-)
+	"github.com/panjf2000/gnet/v2/pkg/pool/goroutine"
 
-var (
-	errMsg      = "Internal Server Error"
-	errMsgBytes = []byte(errMsg)
+	"github.com/gnet-io/gnet-examples/config"
 )
 
+// Handler responds to an HTTP request.
+type Handler interface {
+	ServeHTTP(w ResponseWriter, r *Request)
+}
+
+// HandlerFunc adapts an ordinary function to a Handler.
+type HandlerFunc func(w ResponseWriter, r *Request)
+
+// ServeHTTP calls f(w, r).
+func (f HandlerFunc) ServeHTTP(w ResponseWriter, r *Request) {
+	f(w, r)
+}
+
 type httpServer struct {
 	gnet.BuiltinEventEngine
 
 	addr      string
 	multicore bool
 	eng       gnet.Engine
-}
+	handler   Handler
+	// router is where the routes having a say in how request bodies are received are looked up, see bodyRoute.
+	router      *router
+	maxBody     int64
+	maxRequests int
+	idleTimeout time.Duration
+	conns       sync.Map
+	date        dateCache
 
-type httpCodec struct {
-	parser *wildcat.HTTPParser
-	buf    []byte
-}
+	// Limits on the head of requests, a request beyond them is answered with a 414 or a 431,
+	// and with a 408 if it's not fully received within requestTimeout.
+	maxRequestLine int
+	maxHeaderCount int
+	maxHeaderBytes int
+	requestTimeout time.Duration
 
-func (hc *httpCodec) appendResponse() {
-	hc.buf = append(hc.buf, "HTTP/1.1 200 OK\r\nServer: gnet\r\nContent-Type: text/plain\r\nDate: "...)
-	hc.buf = time.Now().AppendFormat(hc.buf, "Mon, 02 Jan 2006 15:04:05 GMT")
-	hc.buf = append(hc.buf, "\r\nContent-Length: 12\r\n\r\nHello World!"...)
+	// h2c enables HTTP/2 for the clients that open connections with its preface.
+	h2c bool
 }
 
 func (hs *httpServer) OnBoot(eng gnet.Engine) gnet.Action {
@@ -41,46 +67,445 @@ func (hs *httpServer) OnBoot(eng gnet.Engine) gnet.Action {
 }
 
 func (hs *httpServer) OnOpen(c gnet.Conn) ([]byte, gnet.Action) {
-	c.SetContext(&httpCodec{parser: wildcat.NewHTTPParser()})
+	hc := newHTTPCodec(c, hs)
+	hc.idleSince = time.Now().UnixNano()
+	c.SetContext(hc)
+	hs.conns.Store(c, hc)
 	return nil, gnet.None
 }
 
+func (hs *httpServer) OnClose(c gnet.Conn, _ error) gnet.Action {
+	switch ctx := c.Context().(type) {
+	case *httpCodec:
+		hs.conns.Delete(c)
+		ctx.abortBody(errBodyAborted)
+		close(ctx.closed)
+	case *h2Conn:
+		hs.conns.Delete(c)
+		for _, st := range ctx.streams {
+			ctx.abortBody(st, errBodyAborted)
+		}
+		close(ctx.hc.closed)
+	}
+	return gnet.None
+}
+
+// OnTick refreshes the Date header, closes the keep-alive connections that have been idle for longer than
+// idleTimeout and times out the requests that haven't been fully received within requestTimeout.
+func (hs *httpServer) OnTick() (time.Duration, gnet.Action) {
+	now := time.Now()
+	hs.date.update(now)
+	idleDeadline := now.Add(-hs.idleTimeout).UnixNano()
+	requestDeadline := now.Add(-hs.requestTimeout).UnixNano()
+	hs.conns.Range(func(key, value interface{}) bool {
+		c, hc := key.(gnet.Conn), value.(*httpCodec)
+		if since := atomic.LoadInt64(&hc.idleSince); hs.idleTimeout > 0 && since != 0 && since < idleDeadline {
+			hs.closeIdle(c, hc, since)
+		} else if since := atomic.LoadInt64(&hc.waitingSince); hs.requestTimeout > 0 && since != 0 && since < requestDeadline {
+			hs.timeout(c, hc, since)
+		}
+		return true
+	})
+	return time.Second, gnet.None
+}
+
+// closeIdle closes a connection that has been idle since since, unless it has got busy in the meantime.
+// An HTTP/2 client is sent a GOAWAY first, for it to know that no stream got lost, see RFC 9113, section 6.8.
+func (hs *httpServer) closeIdle(c gnet.Conn, hc *httpCodec, since int64) {
+	_ = c.AsyncWrite(nil, func(c gnet.Conn) error {
+		if atomic.LoadInt64(&hc.idleSince) != since {
+			return nil
+		}
+		if h2, ok := c.Context().(*h2Conn); ok {
+			h2.out = appendGoAway(h2.out, h2.lastStream, errCodeNo, "idle timeout")
+			h2.flush()
+		}
+		return c.Close(nil)
+	})
+}
+
+// timeout answers a request that has been partially received since since with a 408 and closes the connection,
+// unless the request has made it in the meantime.
+func (hs *httpServer) timeout(c gnet.Conn, hc *httpCodec, since int64) {
+	_ = c.AsyncWrite(nil, func(c gnet.Conn) error {
+		if atomic.LoadInt64(&hc.waitingSince) != since {
+			return nil
+		}
+		atomic.StoreInt64(&hc.waitingSince, 0)
+		_, _ = c.Write(appendError(nil, http.StatusRequestTimeout, hs.date.get()))
+		return c.Close(nil)
+	})
+}
+
+// OnTraffic serves every complete request in the inbound buffer, a request whose headers or body
+// haven't fully arrived yet stays in the buffer until the next read completes it.
+// Connections upgraded to WebSocket, or speaking HTTP/2, get their frames decoded instead.
 func (hs *httpServer) OnTraffic(c gnet.Conn) gnet.Action {
+	buf, _ := c.Peek(-1)
+	switch ctx := c.Context().(type) {
+	case *wsConn:
+		return hs.serveWebSocket(c, ctx, buf)
+	case *h2Conn:
+		return hs.serveH2(c, ctx, buf)
+	case *httpCodec:
+		// HTTP/2 clients with prior knowledge open with the connection preface rather than a request.
+		if hs.h2c && ctx.requests == 0 && ctx.pending == nil {
+			if bytes.HasPrefix(buf, h2Preface) {
+				h2 := newH2Conn(ctx)
+				c.SetContext(h2)
+				return hs.serveH2(c, h2, buf)
+			}
+			if len(buf) < len(h2Preface) && bytes.HasPrefix(h2Preface, buf) {
+				return gnet.None
+			}
+		}
+	}
+	return hs.serve(c, buf)
+}
+
+// resume serves the requests held back while a detached response was in progress, it runs on the event-loop.
+// Outside of OnTraffic gnet still counts the slice of the last read as buffered, even though what's left of it
+// has been copied into the inbound buffer already, so only the bytes known to be unread are peeked.
+func (hs *httpServer) resume(c gnet.Conn) error {
 	hc := c.Context().(*httpCodec)
-	buf, _ := c.Next(-1)
+	buf, _ := c.Peek(hc.unread)
+	if hs.serve(c, buf) == gnet.Close {
+		return c.Close(nil)
+	}
+	return nil
+}
 
-pipeline:
-	headerOffset, err := hc.parser.Parse(buf)
+func (hs *httpServer) serve(c gnet.Conn, buf []byte) gnet.Action {
+	hc := c.Context().(*httpCodec)
+	consumed, err := hc.decode(hs.handler, buf)
 	if err != nil {
-		c.Write(errMsgBytes)
+		// The responses to the requests that came before the bad one go out first.
+		hc.buf = appendError(hc.buf, errorStatus(err), hs.date.get())
+		_, _ = c.Write(hc.buf)
+		hc.buf = hc.buf[:0]
+		return gnet.Close
+	}
+	// The request bodies handed to handlers point into buf, so it can't be discarded any earlier,
+	// and Discard(0) would drop the whole buffer, partial request included.
+	if consumed > 0 {
+		_, _ = c.Discard(consumed)
+	}
+	hc.unread = len(buf) - consumed
+
+	if len(hc.buf) > 0 {
+		c.Write(hc.buf)
+		hc.buf = hc.buf[:0]
+	}
+	if hc.closing {
+		// The data still buffered is dropped, gnet flushes the responses before closing.
 		return gnet.Close
 	}
-	hc.appendResponse()
-	bodyLen := int(hc.parser.ContentLength())
-	if bodyLen == -1 {
-		bodyLen = 0
+	if hc.upgrade != nil {
+		return hs.switchProtocols(c, hc, buf[consumed:])
 	}
-	buf = buf[headerOffset+bodyLen:]
-	if len(buf) > 0 {
-		goto pipeline
+	now := time.Now().UnixNano()
+	var idleSince, waitingSince int64
+	switch {
+	case hc.busy:
+	case hc.pending == nil && hc.unread == 0:
+		idleSince = now
+	case hc.pending != nil && hc.pending.Sink != nil:
+		// A body going to a sink may be large, it times out when it stops arriving rather than when it's slow.
+		waitingSince = now
+	default:
+		// The clock of a partially received request starts with its first bytes, not with the last read.
+		waitingSince = hc.waitingSince
+		if waitingSince == 0 || hc.waitingFor != hc.requests {
+			waitingSince, hc.waitingFor = now, hc.requests
+		}
 	}
+	atomic.StoreInt64(&hc.idleSince, idleSince)
+	atomic.StoreInt64(&hc.waitingSince, waitingSince)
+	return gnet.None
+}
+
+// switchProtocols swaps the context of the connection for the WebSocket connection a handler has upgraded it to,
+// which goes on with the bytes that followed the upgrade request. The connection is no longer subject to the
+// idle and request timeouts of HTTP then.
+func (hs *httpServer) switchProtocols(c gnet.Conn, hc *httpCodec, rest []byte) gnet.Action {
+	wc := hc.upgrade
+	c.SetContext(wc)
+	hs.conns.Delete(c)
+	close(hc.closed)
+	return hs.serveWebSocket(c, wc, rest)
+}
 
-	c.Write(hc.buf)
-	hc.buf = hc.buf[:0]
+func (hs *httpServer) serveWebSocket(c gnet.Conn, wc *wsConn, buf []byte) gnet.Action {
+	consumed, err := wc.decode(buf)
+	if consumed > 0 {
+		_, _ = c.Discard(consumed)
+	}
+	if err != nil {
+		// gnet flushes the close frame before closing.
+		return gnet.Close
+	}
+	return gnet.None
+}
+
+// serveH2 handles the frames of an HTTP/2 connection, a connection error is answered with a GOAWAY.
+func (hs *httpServer) serveH2(c gnet.Conn, h2 *h2Conn, buf []byte) gnet.Action {
+	consumed, err := h2.decode(buf)
+	if consumed > 0 {
+		_, _ = c.Discard(consumed)
+	}
+	if err != nil {
+		ce := err.(*h2ConnError)
+		h2.out = appendGoAway(h2.out, h2.lastStream, ce.code, ce.reason)
+		h2.flush()
+		return gnet.Close
+	}
+	h2.flush()
+	h2.touch()
+	if h2.done() {
+		return gnet.Close
+	}
 	return gnet.None
 }
 
 func main() {
 	var port int
-	var multicore bool
+	var maxBody int64
+	var maxRequests int
+	var maxRequestLine, maxHeaderCount, maxHeaderBytes int
+	var requestTimeout time.Duration
+	var idleTimeout time.Duration
+	var root string
+	var compression bool
+	var compressMinSize int
+	var accessLogPath, accessLogFormat string
+	var accessLogMaxSize int64
+	var accessLogBackups int
+	var proxy, lbStrategy, healthCheckPath string
+	var healthCheckInterval, proxyTimeout time.Duration
+	var sseRetry, sseHeartbeat time.Duration
+	var sseReplay int
+	var h2c bool
+	var uploadDir string
+	var maxUpload int64
+	var corsOrigins, corsMethods, corsHeaders string
+	var corsCredentials bool
+	var corsMaxAge time.Duration
+	var secureHeaders bool
+	var hstsMaxAge time.Duration
+	var csp string
+	var requestIDs bool
+	cfg := config.Server{Multicore: true}
 
-	// Example command: go run main.go --port 8080 --multicore=true
-	flag.IntVar(&port, "port", 9080, "server port")
-	flag.BoolVar(&multicore, "multicore", true, "multicore")
+	// Example command: go run . --port 8080 --multicore=true
+	// Listening beyond the loopback: go run . --listen tcp://0.0.0.0:8080 --listen tcp://[::]:8080 --listen unix:///tmp/http.sock
+	// Settings can also come from a file and the environment: GNET_IDLE_TIMEOUT=2m go run . --config http.yaml
+	cfg.Flags(flag.CommandLine)
+	flag.IntVar(&port, "port", 9080, "server port, listened on 127.0.0.1 when no --listen is given")
+	flag.Int64Var(&maxBody, "max_body", 10<<20, "max request body size in bytes, 0 means unlimited")
+	flag.StringVar(&uploadDir, "upload_dir", os.TempDir(), "directory the bodies posted to /upload are stored in")
+	flag.Int64Var(&maxUpload, "max_upload", 1<<30, "max size in bytes of a body posted to /upload, 0 means unlimited")
+	flag.IntVar(&maxRequests, "max_requests", 0, "max requests served on a connection before closing it, 0 means unlimited")
+	flag.StringVar(&root, "root", ".", "directory served under /static/")
+	flag.BoolVar(&compression, "compress", true, "compress responses with gzip or deflate when the client accepts it")
+	flag.IntVar(&compressMinSize, "compress_min_size", 1024, "min size in bytes of a response body to compress")
+	flag.StringVar(&accessLogPath, "access_log", "", "access log file, - for the standard output, empty disables it")
+	flag.StringVar(&accessLogFormat, "access_log_format", logFormatCombined, "access log format: common, combined or json")
+	flag.Int64Var(&accessLogMaxSize, "access_log_max_size", 100<<20, "rotate the access log once it reaches this size in bytes, 0 disables rotation")
+	flag.IntVar(&accessLogBackups, "access_log_backups", 5, "number of rotated access logs to keep")
+	flag.IntVar(&maxRequestLine, "max_request_line", 8<<10, "max length in bytes of a request line, longer ones get a 414")
+	flag.IntVar(&maxHeaderCount, "max_header_count", 100, "max number of request header fields, more get a 431")
+	flag.IntVar(&maxHeaderBytes, "max_header_bytes", 64<<10, "max size in bytes of the request header fields, larger ones get a 431")
+	flag.DurationVar(&requestTimeout, "request_timeout", 30*time.Second, "max time to receive a request, slower ones get a 408, 0 disables it")
+	flag.DurationVar(&idleTimeout, "idle_timeout", 60*time.Second, "close keep-alive connections idle for this long, 0 disables it")
+	// Reverse proxy: go run . --proxy "/api=127.0.0.1:8081,127.0.0.1:8082;/auth=127.0.0.1:8083" --lb least_conn
+	flag.StringVar(&proxy, "proxy", "", "upstream pools by path prefix, as prefix=addr,addr;prefix=addr")
+	flag.StringVar(&lbStrategy, "lb", lbRoundRobin, "load balancing strategy: round_robin, least_conn or consistent_hash")
+	flag.StringVar(&healthCheckPath, "health_check_path", "/", "path requested from upstreams to check their health")
+	flag.DurationVar(&healthCheckInterval, "health_check_interval", 5*time.Second, "interval of the upstream health checks, 0 disables them")
+	flag.DurationVar(&proxyTimeout, "proxy_timeout", 30*time.Second, "max time for an upstream to answer, slower ones get a 504, 0 disables it")
+	// Cross-origin requests: go run . --cors_origins https://app.example.com --cors_credentials
+	flag.StringVar(&corsOrigins, "cors_origins", "", "origins allowed to make cross-origin requests, as origin,origin or *, empty disables CORS")
+	flag.StringVar(&corsMethods, "cors_methods", "GET,HEAD,POST,PUT,PATCH,DELETE", "methods allowed in cross-origin requests")
+	flag.StringVar(&corsHeaders, "cors_headers", "", "request headers allowed in cross-origin requests, empty allows those asked for")
+	flag.BoolVar(&corsCredentials, "cors_credentials", false, "allow cross-origin requests with credentials, cookies say")
+	flag.DurationVar(&corsMaxAge, "cors_max_age", 10*time.Minute, "how long browsers may cache the outcome of a preflight request")
+	flag.BoolVar(&secureHeaders, "secure_headers", true, "add security headers to responses: X-Content-Type-Options, X-Frame-Options, Referrer-Policy...")
+	flag.DurationVar(&hstsMaxAge, "hsts_max_age", 365*24*time.Hour, "max-age of the Strict-Transport-Security header, 0 leaves it out")
+	flag.StringVar(&csp, "csp", "", "Content-Security-Policy header of responses, empty leaves it out")
+	flag.BoolVar(&requestIDs, "request_id", true, "give every request an ID, taken from X-Request-ID or generated, echoed in responses and access logs")
+	flag.BoolVar(&h2c, "h2c", true, "serve HTTP/2 to the clients connecting with prior knowledge, as with curl --http2-prior-knowledge")
+	flag.DurationVar(&sseRetry, "sse_retry", 3*time.Second, "reconnection delay suggested to event stream clients")
+	flag.DurationVar(&sseHeartbeat, "sse_heartbeat", 15*time.Second, "interval of the comments sent on idle event streams, 0 disables them")
+	flag.IntVar(&sseReplay, "sse_replay", 256, "number of recent events replayed to clients resuming with Last-Event-ID")
 	flag.Parse()
+	if err := cfg.Load(flag.CommandLine); err != nil {
+		log.Fatal(err)
+	}
+	if len(cfg.Listen) == 0 {
+		cfg.Listen = []string{fmt.Sprintf("tcp://127.0.0.1:%d", port)}
+	}
+	if err := cfg.Only("tcp", "unix"); err != nil {
+		log.Fatal(err)
+	}
 
-	hs := &httpServer{addr: fmt.Sprintf("tcp://127.0.0.1:%d", port), multicore: multicore}
+	rt := newRouter()
+	rt.HandleFunc(http.MethodGet, "/", func(w ResponseWriter, r *Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("Hello World!"))
+	})
+	rt.HandleFunc(http.MethodGet, "/hello/:name", func(w ResponseWriter, r *Request) {
+		_, _ = fmt.Fprintf(w, "Hello, %s!\n", r.Params.ByName("name"))
+	})
+	rt.HandleFunc(http.MethodGet, "/files/*filepath", func(w ResponseWriter, r *Request) {
+		_, _ = fmt.Fprintf(w, "file: /%s\n", r.Params.ByName("filepath"))
+	})
+	rt.HandleFunc(http.MethodPost, "/echo", func(w ResponseWriter, r *Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "" {
+			w.Header().Set("Content-Type", ct)
+		}
+		_, _ = w.Write(r.Body)
+		for k, v := range r.Trailer {
+			w.Header()["X-Trailer-"+k] = v
+		}
+	})
+	// Uploads are written to disk as they arrive, rather than buffered up to --max_body.
+	rt.Handle(http.MethodPost, "/upload", receiveFiles(uploadDir, maxUpload))
+	rt.HandleFunc(http.MethodGet, "/stream", func(w ResponseWriter, r *Request) {
+		// Flushing before the handler returns switches to chunked Transfer-Encoding.
+		n, _ := strconv.Atoi(r.Query().Get("n"))
+		for i := 0; i < n; i++ {
+			_, _ = fmt.Fprintf(w, "chunk %d\n", i)
+			w.Flush()
+		}
+	})
+
+	// Plain net/http handlers are mounted under /std, they run on a worker pool.
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sleep", func(w http.ResponseWriter, r *http.Request) {
+		d, err := time.ParseDuration(r.URL.Query().Get("d"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		time.Sleep(d)
+		_, _ = fmt.Fprintf(w, "slept %v\n", d)
+	})
+	mux.HandleFunc("/info", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"method": r.Method, "uri": r.RequestURI, "host": r.Host, "remote": r.RemoteAddr, "header": r.Header,
+		})
+	})
+	std := adaptHandler(http.StripPrefix("/std", mux), goroutine.Default())
+	for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+		rt.Handle(method, "/std/*path", std)
+	}
+
+	rt.Handle(http.MethodGet, "/static/*filepath", serveFiles(root, goroutine.Default()))
+
+	// WebSocket clients share the port with the REST routes, messages sent to /ws are echoed back.
+	rt.Handle(http.MethodGet, "/ws", upgradeWebSocket(func(wc *wsConn, op ws.OpCode, payload []byte) {
+		_ = wc.WriteMessage(op, payload)
+	}))
+
+	// Event streams: subscribe with GET /events, publish with POST /events?event=type, the body being the data.
+	broker := newSSEBroker(sseRetry, sseHeartbeat, sseReplay)
+	defer broker.close()
+	rt.Handle(http.MethodGet, "/events", broker)
+	rt.Handle(http.MethodPost, "/events", MaxBody(HandlerFunc(func(w ResponseWriter, r *Request) {
+		id, err := broker.Publish(r.Query().Get("event"), string(r.Body))
+		if err != nil {
+			http.Error(w, "400 bad request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = fmt.Fprintf(w, "published event %d\n", id)
+	}), 64<<10))
+
+	if proxy != "" {
+		routes, err := parseProxyRoutes(proxy)
+		if err != nil {
+			log.Fatalf("failed to parse proxy routes: %v", err)
+		}
+		eng, err := newUpstreamEngine(proxyTimeout, goroutine.Default())
+		if err != nil {
+			log.Fatalf("failed to start upstream client: %v", err)
+		}
+		defer eng.stop()
+		for _, route := range routes {
+			pool, err := newUpstreamPool(route.addrs, lbStrategy)
+			if err != nil {
+				log.Fatalf("invalid upstream pool for %s: %v", route.prefix, err)
+			}
+			if healthCheckInterval > 0 {
+				pool.watchHealth(healthCheckPath, healthCheckInterval, healthCheckInterval)
+			}
+			rp := &reverseProxy{pool: pool, eng: eng}
+			for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch,
+				http.MethodDelete, http.MethodOptions} {
+				rt.Handle(method, route.prefix, rp)
+				rt.Handle(method, route.prefix+"/*path", rp)
+			}
+		}
+	}
+
+	var mws []Middleware
+	if requestIDs {
+		mws = append(mws, requestID())
+	}
+	if accessLogPath != "" {
+		al, err := newAccessLogger(accessLogPath, accessLogFormat, accessLogMaxSize, accessLogBackups)
+		if err != nil {
+			log.Fatalf("failed to open access log: %v", err)
+		}
+		defer al.close()
+		mws = append(mws, accessLog(al))
+	}
+	if secureHeaders {
+		mws = append(mws, secure(securityHeaders(hstsMaxAge, csp)))
+	}
+	if corsOrigins != "" {
+		policy := &corsPolicy{
+			origins:     splitList(corsOrigins),
+			methods:     splitList(corsMethods),
+			headers:     splitList(corsHeaders),
+			credentials: corsCredentials,
+			maxAge:      corsMaxAge,
+		}
+		if policy.credentials && policy.allowsOrigin("*") {
+			log.Fatal("--cors_credentials can't be combined with the * origin, list the origins allowed credentials")
+		}
+		if requestIDs {
+			policy.expose = []string{"X-Request-Id"}
+		}
+		mws = append(mws, cors(policy))
+	}
+	if compression {
+		mws = append(mws, compress(gzip.DefaultCompression, compressMinSize))
+	}
+
+	// Every URL gets a server of its own, which times out the connections of its engine.
+	handler := chain(rt, mws...)
+	newServer := func(url string) gnet.EventHandler {
+		hs := &httpServer{
+			addr:        url,
+			multicore:   cfg.Multicore,
+			handler:     handler,
+			router:      rt,
+			maxBody:     maxBody,
+			maxRequests: maxRequests,
+			idleTimeout: idleTimeout,
+
+			maxRequestLine: maxRequestLine,
+			maxHeaderCount: maxHeaderCount,
+			maxHeaderBytes: maxHeaderBytes,
+			requestTimeout: requestTimeout,
+			h2c:            h2c,
+		}
+		hs.date.update(time.Now())
+		return hs
+	}
 
 	// Start serving!
-	log.Println("server exits:", gnet.Run(hs, hs.addr, gnet.WithMulticore(multicore)))
+	log.Println("server exits:", cfg.Run(newServer, gnet.WithTicker(true)))
 }
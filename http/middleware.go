@@ -0,0 +1,12 @@
+package main
+
+// Middleware wraps a Handler with behaviour of its own, before and after it runs.
+type Middleware func(next Handler) Handler
+
+// chain wraps h with mws, the first middleware being the outermost one, that is the first to see a request.
+func chain(h Handler, mws ...Middleware) Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+)
+
+var (
+	errMalformedRequest        = &statusError{http.StatusBadRequest, "malformed request"}
+	errMissingHost             = &statusError{http.StatusBadRequest, "missing or duplicate Host"}
+	errRequestLineTooLong      = &statusError{http.StatusRequestURITooLong, "request line too long"}
+	errHeaderTooLarge          = &statusError{http.StatusRequestHeaderFieldsTooLarge, "request header too large"}
+	errTooManyHeaders          = &statusError{http.StatusRequestHeaderFieldsTooLarge, "too many request header fields"}
+	errBadContentLength        = &statusError{http.StatusBadRequest, "invalid Content-Length"}
+	errBadFraming              = &statusError{http.StatusBadRequest, "conflicting Transfer-Encoding and Content-Length"}
+	errUnsupportedTransferCode = &statusError{http.StatusNotImplemented, "unsupported Transfer-Encoding"}
+	errUnsupportedVersion      = &statusError{http.StatusHTTPVersionNotSupported, "unsupported HTTP version"}
+)
+
+// span locates a part of a request head by its offsets from the start of the request.
+type span struct {
+	start, end int
+}
+
+func (s span) of(data []byte) []byte {
+	return data[s.start:s.end]
+}
+
+// headerField is a header field line, its value stripped of surrounding whitespace.
+type headerField struct {
+	name, value span
+}
+
+type parserState int
+
+const (
+	parseRequestLine parserState = iota
+	parseHeaders
+	parseDone
+)
+
+// requestParser parses a request head, see RFC 9112, sections 3 and 5.
+//
+// It works on the inbound buffer as is: every call gets the bytes of the request seen so far and picks up
+// at the first line it hasn't parsed yet. Parts of the head are kept as offsets rather than slices, as the
+// bytes may have moved between two calls, so parsing allocates nothing once the header slice has grown.
+type requestParser struct {
+	maxRequestLine int
+	maxHeaderCount int
+	maxHeaderBytes int
+
+	state   parserState
+	pos     int
+	fields  int
+	method  span
+	target  span
+	version span
+	headers []headerField
+
+	// What the framing and the routing of a request depend on, gathered while parsing.
+	contentLength    int64
+	transferEncoding bool
+	chunked          bool
+	host             span
+	hosts            int
+}
+
+func (p *requestParser) reset() {
+	p.state, p.pos = parseRequestLine, 0
+	p.headers = p.headers[:0]
+	p.contentLength, p.transferEncoding, p.chunked = -1, false, false
+	p.host, p.hosts = span{}, 0
+}
+
+// parse continues parsing the request head at the start of data and returns its length once complete,
+// or errIncompleteRequest if more data is needed. data must start with the bytes passed to the previous call
+// since reset. Once the head is complete, parse keeps returning its length until the parser is reset.
+func (p *requestParser) parse(data []byte) (int, error) {
+	for p.state != parseDone {
+		i := bytes.IndexByte(data[p.pos:], '\n')
+		if i < 0 {
+			return 0, p.checkIncomplete(len(data))
+		}
+		start, next := p.pos, p.pos+i+1
+		end := next - 1
+		if end > start && data[end-1] == '\r' {
+			end--
+		}
+		var err error
+		switch p.state {
+		case parseRequestLine:
+			if p.maxRequestLine > 0 && next > p.maxRequestLine {
+				return 0, errRequestLineTooLong
+			}
+			// Empty lines received ahead of the request line are ignored, see RFC 9112, section 2.2.
+			if end == start {
+				p.pos = next
+				continue
+			}
+			err = p.parseRequestLine(data, start, end)
+			p.state, p.fields = parseHeaders, next
+		case parseHeaders:
+			if p.maxHeaderBytes > 0 && next-p.fields > p.maxHeaderBytes {
+				return 0, errHeaderTooLarge
+			}
+			if end == start {
+				err = p.finish(data)
+				p.state = parseDone
+			} else {
+				err = p.parseHeaderField(data, start, end)
+			}
+		}
+		if err != nil {
+			return 0, err
+		}
+		p.pos = next
+	}
+	return p.pos, nil
+}
+
+// checkIncomplete enforces the limits on the line that hasn't been terminated yet.
+func (p *requestParser) checkIncomplete(n int) error {
+	switch {
+	case p.state == parseRequestLine && p.maxRequestLine > 0 && n > p.maxRequestLine:
+		return errRequestLineTooLong
+	case p.state == parseHeaders && p.maxHeaderBytes > 0 && n-p.fields > p.maxHeaderBytes:
+		return errHeaderTooLarge
+	}
+	return errIncompleteRequest
+}
+
+// parseRequestLine parses method SP request-target SP HTTP-version.
+func (p *requestParser) parseRequestLine(data []byte, start, end int) error {
+	line := data[start:end]
+	sp1 := bytes.IndexByte(line, ' ')
+	if sp1 <= 0 {
+		return errMalformedRequest
+	}
+	sp2 := bytes.IndexByte(line[sp1+1:], ' ')
+	if sp2 <= 0 {
+		return errMalformedRequest
+	}
+	sp2 += sp1 + 1
+	method, target, version := line[:sp1], line[sp1+1:sp2], line[sp2+1:]
+	if !isToken(method) {
+		return errMalformedRequest
+	}
+	for _, c := range target {
+		if c <= ' ' || c == 0x7f {
+			return errMalformedRequest
+		}
+	}
+	if err := checkVersion(version); err != nil {
+		return err
+	}
+	p.method = span{start, start + sp1}
+	p.target = span{start + sp1 + 1, start + sp2}
+	p.version = span{start + sp2 + 1, end}
+	return nil
+}
+
+// checkVersion accepts HTTP/1.0 and HTTP/1.1, as well as later HTTP/1.x versions, which are served as HTTP/1.1.
+func checkVersion(version []byte) error {
+	if len(version) != len("HTTP/1.1") || !bytes.HasPrefix(version, []byte("HTTP/")) || version[6] != '.' ||
+		!isDigit(version[5]) || !isDigit(version[7]) {
+		return errMalformedRequest
+	}
+	if version[5] != '1' {
+		return errUnsupportedVersion
+	}
+	return nil
+}
+
+// parseHeaderField parses field-name ":" OWS field-value OWS, obsolete line folding is rejected.
+func (p *requestParser) parseHeaderField(data []byte, start, end int) error {
+	if p.maxHeaderCount > 0 && len(p.headers) >= p.maxHeaderCount {
+		return errTooManyHeaders
+	}
+	line := data[start:end]
+	colon := bytes.IndexByte(line, ':')
+	// No whitespace is allowed between the field name and the colon, nor ahead of the name,
+	// which would be a folded line.
+	if colon <= 0 || !isToken(line[:colon]) {
+		return errMalformedRequest
+	}
+	vs, ve := colon+1, len(line)
+	for vs < ve && (line[vs] == ' ' || line[vs] == '\t') {
+		vs++
+	}
+	for ve > vs && (line[ve-1] == ' ' || line[ve-1] == '\t') {
+		ve--
+	}
+	if !isFieldValue(line[vs:ve]) {
+		return errMalformedRequest
+	}
+	f := headerField{name: span{start, start + colon}, value: span{start + vs, start + ve}}
+	p.headers = append(p.headers, f)
+	return p.noteField(f.name.of(data), f.value.of(data), f.value)
+}
+
+var (
+	contentLengthHeader    = []byte("Content-Length")
+	transferEncodingHeader = []byte("Transfer-Encoding")
+	hostHeader             = []byte("Host")
+	chunked                = []byte("chunked")
+)
+
+// noteField records the fields that determine the framing of the request.
+func (p *requestParser) noteField(name, value []byte, vspan span) error {
+	switch {
+	case bytes.EqualFold(name, contentLengthHeader):
+		n, ok := parseContentLength(value)
+		// Repeated fields must agree, see RFC 9112, section 6.3.
+		if !ok || p.contentLength >= 0 && p.contentLength != n {
+			return errBadContentLength
+		}
+		p.contentLength = n
+	case bytes.EqualFold(name, transferEncodingHeader):
+		// Only the chunked coding is supported, and it must come last, see RFC 9112, section 6.1.
+		p.transferEncoding = true
+		for rest := value; len(rest) > 0; {
+			var coding []byte
+			if coding, rest = nextElement(rest); len(coding) == 0 {
+				continue
+			}
+			if !bytes.EqualFold(coding, chunked) || p.chunked {
+				return errUnsupportedTransferCode
+			}
+			p.chunked = true
+		}
+	case bytes.EqualFold(name, hostHeader):
+		p.host = vspan
+		p.hosts++
+	}
+	return nil
+}
+
+// finish validates the head as a whole once the empty line ending it has been seen.
+func (p *requestParser) finish(data []byte) error {
+	http10 := bytes.Equal(p.version.of(data), []byte("HTTP/1.0"))
+	if !http10 && p.hosts != 1 || p.hosts > 1 {
+		return errMissingHost
+	}
+	if p.transferEncoding {
+		// A message with both Transfer-Encoding and Content-Length is a request smuggling attempt,
+		// and HTTP/1.0 has no transfer codings at all, see RFC 9112, section 6.1.
+		if p.contentLength >= 0 || http10 || !p.chunked {
+			return errBadFraming
+		}
+	}
+	return nil
+}
+
+// parseContentLength parses 1*DIGIT, a list of identical values such as "5, 5" is accepted too.
+func parseContentLength(value []byte) (int64, bool) {
+	n := int64(-1)
+	for rest := value; len(rest) > 0 || n < 0; {
+		var elem []byte
+		elem, rest = nextElement(rest)
+		if len(elem) == 0 || len(elem) > 18 {
+			return 0, false
+		}
+		var v int64
+		for _, c := range elem {
+			if !isDigit(c) {
+				return 0, false
+			}
+			v = v*10 + int64(c-'0')
+		}
+		if n >= 0 && v != n {
+			return 0, false
+		}
+		n = v
+	}
+	return n, n >= 0
+}
+
+// nextElement splits the first element off a comma-separated list, trimmed of whitespace.
+func nextElement(list []byte) (elem, rest []byte) {
+	if i := bytes.IndexByte(list, ','); i >= 0 {
+		elem, rest = list[:i], list[i+1:]
+	} else {
+		elem = list
+	}
+	return bytes.Trim(elem, " \t"), rest
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// tokenChars marks the tchar of RFC 9110, section 5.6.2.
+var tokenChars = func() (t [256]bool) {
+	for c := '0'; c <= '9'; c++ {
+		t[c] = true
+	}
+	for c := 'a'; c <= 'z'; c++ {
+		t[c] = true
+		t[c-'a'+'A'] = true
+	}
+	for _, c := range "!#$%&'*+-.^_`|~" {
+		t[c] = true
+	}
+	return
+}()
+
+// isFieldValue reports whether b is a valid field value, which has no control characters but tabs,
+// see RFC 9110, section 5.5.
+func isFieldValue(b []byte) bool {
+	for _, c := range b {
+		if c < ' ' && c != '\t' || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+func isToken(b []byte) bool {
+	if len(b) == 0 {
+		return false
+	}
+	for _, c := range b {
+		if !tokenChars[c] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,19 @@
+//go:build go1.18
+// +build go1.18
+
+package main
+
+import "testing"
+
+// FuzzParse checks the parser on arbitrary input, see checkParse. Run it with go test -fuzz FuzzParse.
+func FuzzParse(f *testing.F) {
+	for _, s := range parseCorpus {
+		f.Add([]byte(s))
+	}
+	for _, s := range malformedCorpus {
+		f.Add([]byte(s))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		checkParse(t, data)
+	})
+}
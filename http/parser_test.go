@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/evanphx/wildcat"
+)
+
+// parseCorpus holds the requests the parser is benchmarked on, and the seeds of its fuzzing.
+var parseCorpus = []string{
+	"GET / HTTP/1.1\r\nHost: localhost:9080\r\nUser-Agent: curl/7.88.1\r\nAccept: */*\r\n\r\n",
+	"GET /static/css/site.css?v=3 HTTP/1.1\r\n" +
+		"Host: www.example.com\r\n" +
+		"User-Agent: Mozilla/5.0 (X11; Linux x86_64; rv:109.0) Gecko/20100101 Firefox/118.0\r\n" +
+		"Accept: text/css,*/*;q=0.1\r\n" +
+		"Accept-Language: en-US,en;q=0.5\r\n" +
+		"Accept-Encoding: gzip, deflate, br\r\n" +
+		"Referer: https://www.example.com/\r\n" +
+		"Connection: keep-alive\r\n" +
+		"Cookie: session=8c6a7d1f0e2b4a39; theme=dark\r\n" +
+		"If-None-Match: \"5d8c72a5edda8\"\r\n" +
+		"Cache-Control: max-age=0\r\n\r\n",
+	"POST /echo HTTP/1.1\r\nHost: api.example.com\r\nContent-Type: application/json\r\nContent-Length: 17\r\n\r\n{\"hello\":\"world\"}",
+	"GET /hello/gnet HTTP/1.0\r\nUser-Agent: ab/2.3\r\n\r\n",
+}
+
+// malformedCorpus holds requests the parser must reject, more seeds for the fuzzing.
+var malformedCorpus = []string{
+	"GET /\r\n\r\n",
+	"GET / HTTP/1.1\r\n\r\n",
+	"GET / HTTP/2.0\r\nHost: x\r\n\r\n",
+	"GET / HTTP/1.1\r\nHost: x\r\n folded\r\n\r\n",
+	"GET / HTTP/1.1\r\nHost : x\r\n\r\n",
+	"GET / HTTP/1.1\r\nHost: x\r\nHost: y\r\n\r\n",
+	"GET / HTTP/1.1\r\nHost: x\r\nX: a\x00b\r\n\r\n",
+	"POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 1\r\nContent-Length: 2\r\n\r\n",
+	"POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 3\r\nTransfer-Encoding: chunked\r\n\r\n",
+	"POST / HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: gzip\r\n\r\n",
+	"GET /a\x7fb HTTP/1.1\r\nHost: x\r\n\r\n",
+}
+
+func newTestParser() *requestParser {
+	p := &requestParser{maxRequestLine: 256, maxHeaderCount: 12, maxHeaderBytes: 1024}
+	p.reset()
+	return p
+}
+
+// checkParse parses data whole and then byte by byte, as if each byte came in a read of its own,
+// and checks that both agree and that what has been accepted is well-formed.
+func checkParse(t *testing.T, data []byte) {
+	t.Helper()
+	p := newTestParser()
+	n, err := p.parse(data)
+	want := fmt.Sprintf("%d %v", n, err)
+	if err == nil {
+		if n <= 0 || n > len(data) || data[n-1] != '\n' {
+			t.Fatalf("%q: head of %d bytes", data, n)
+		}
+		if !isToken(p.method.of(data)) {
+			t.Fatalf("%q: accepted method %q", data, p.method.of(data))
+		}
+		for _, f := range p.headers {
+			if !isToken(f.name.of(data)) || !isFieldValue(f.value.of(data)) {
+				t.Fatalf("%q: accepted field %q: %q", data, f.name.of(data), f.value.of(data))
+			}
+		}
+		if p.transferEncoding && p.contentLength >= 0 {
+			t.Fatalf("%q: accepted both Transfer-Encoding and Content-Length", data)
+		}
+	}
+
+	p = newTestParser()
+	got := fmt.Sprintf("0 %v", errIncompleteRequest)
+	for i := 1; i <= len(data); i++ {
+		n, err := p.parse(data[:i])
+		if err != errIncompleteRequest {
+			got = fmt.Sprintf("%d %v", n, err)
+			break
+		}
+	}
+	if got != want {
+		t.Fatalf("%q: parsed byte by byte to %s, whole to %s", data, got, want)
+	}
+}
+
+// mutations derives variants of a seed, each with one byte replaced by one that matters to the grammar,
+// or cut short.
+func mutations(seed []byte) [][]byte {
+	var variants [][]byte
+	for i := range seed {
+		for _, c := range []byte{'\r', '\n', ' ', '\t', ':', ',', '0', 'a', 0, 0x7f, 0x80} {
+			if seed[i] == c {
+				continue
+			}
+			v := append([]byte(nil), seed...)
+			v[i] = c
+			variants = append(variants, v)
+		}
+		variants = append(variants, seed[:i])
+	}
+	return variants
+}
+
+// TestParseCorpus runs the checks of the fuzzing on the seeds and their single-byte mutations,
+// so that they run with every toolchain, see FuzzParse for the fuzzer proper.
+func TestParseCorpus(t *testing.T) {
+	for _, s := range append(append([]string(nil), parseCorpus...), malformedCorpus...) {
+		seed := []byte(s)
+		checkParse(t, seed)
+		for _, v := range mutations(seed) {
+			checkParse(t, v)
+		}
+	}
+	for _, s := range parseCorpus {
+		p := newTestParser()
+		if n, err := p.parse([]byte(s)); err != nil || n != bytes.Index([]byte(s), []byte("\r\n\r\n"))+4 {
+			t.Errorf("%q: got %d, %v", s, n, err)
+		}
+	}
+	for _, s := range malformedCorpus {
+		p := newTestParser()
+		if _, err := p.parse([]byte(s)); err == nil || err == errIncompleteRequest {
+			t.Errorf("%q: got %v, want an error", s, err)
+		}
+	}
+}
+
+// The parser benchmarks parse the corpus as the codec does: a request received at once, and a request
+// received in two reads, the first one ending in the middle of the header fields. They compare with wildcat,
+// the parser the codec used before, which started over with a new parser after every read.
+
+func BenchmarkParse(b *testing.B) {
+	p := newTestParser()
+	p.maxRequestLine, p.maxHeaderCount, p.maxHeaderBytes = 8<<10, 100, 64<<10
+	benchmarkParsers(b, func(data []byte) (int, error) {
+		p.reset()
+		return p.parse(data)
+	}, func(first, data []byte) (int, error) {
+		p.reset()
+		if _, err := p.parse(first); err != errIncompleteRequest {
+			return 0, fmt.Errorf("first read: %v", err)
+		}
+		return p.parse(data)
+	})
+}
+
+func BenchmarkWildcat(b *testing.B) {
+	parse := func(data []byte) (int, error) {
+		hp := wildcat.NewHTTPParser()
+		n, err := hp.Parse(data)
+		hp.ContentLength()
+		return n, err
+	}
+	benchmarkParsers(b, parse, func(first, data []byte) (int, error) {
+		if _, err := parse(first); err != wildcat.ErrMissingData {
+			return 0, fmt.Errorf("first read: %v", err)
+		}
+		return parse(data)
+	})
+}
+
+func benchmarkParsers(b *testing.B, whole func(data []byte) (int, error), split func(first, data []byte) (int, error)) {
+	var size int64
+	for _, s := range parseCorpus {
+		size += int64(len(s))
+	}
+	corpus := make([][]byte, len(parseCorpus))
+	for i, s := range parseCorpus {
+		corpus[i] = []byte(s)
+	}
+	b.Run("whole", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(size)
+		for i := 0; i < b.N; i++ {
+			for _, data := range corpus {
+				if _, err := whole(data); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	b.Run("split", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(size)
+		for i := 0; i < b.N; i++ {
+			for _, data := range corpus {
+				if _, err := split(data[:len(data)/2], data); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,496 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/panjf2000/gnet/v2"
+	"github.com/panjf2000/gnet/v2/pkg/pool/goroutine"
+)
+
+const (
+	// maxResponseHead bounds the status line and header fields of an upstream response.
+	maxResponseHead = 64 << 10
+	// maxResponseBody bounds an upstream response body, which is buffered in full before it's passed on.
+	maxResponseBody = 64 << 20
+)
+
+var errBadResponse = errors.New("malformed upstream response")
+
+// proxyRoute is a path prefix served by an upstream pool.
+type proxyRoute struct {
+	prefix string
+	addrs  []string
+}
+
+// parseProxyRoutes parses "prefix=addr,addr;prefix=addr", such as "/api=127.0.0.1:8081,127.0.0.1:8082".
+func parseProxyRoutes(s string) ([]proxyRoute, error) {
+	var routes []proxyRoute
+	for _, r := range strings.Split(s, ";") {
+		if r = strings.TrimSpace(r); r == "" {
+			continue
+		}
+		i := strings.IndexByte(r, '=')
+		if i < 0 {
+			return nil, fmt.Errorf("invalid proxy route %q, expect prefix=addr,addr", r)
+		}
+		prefix := strings.TrimRight(strings.TrimSpace(r[:i]), "/")
+		if !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("invalid proxy route %q, the prefix must begin with '/' and not be the root", r)
+		}
+		route := proxyRoute{prefix: prefix}
+		for _, addr := range strings.Split(r[i+1:], ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				route.addrs = append(route.addrs, addr)
+			}
+		}
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+// reverseProxy forwards requests to an upstream pool, the request URI is passed on as is.
+type reverseProxy struct {
+	pool *upstreamPool
+	eng  *upstreamEngine
+}
+
+func (rp *reverseProxy) ServeHTTP(w ResponseWriter, r *Request) {
+	ip := clientIP(r.RemoteAddr)
+	u := rp.pool.pick(ip)
+	if u == nil {
+		http.Error(w, "503 service unavailable: no upstream available", http.StatusServiceUnavailable)
+		return
+	}
+	// The response is completed on the event-loop of the upstream connection.
+	var rw asyncWriter
+	if d, ok := w.(detacher); ok {
+		rw = d.detachWriter()
+	}
+	if rw == nil {
+		http.Error(w, "500 internal server error", http.StatusInternalServerError)
+		return
+	}
+	rp.eng.send(u, &proxyExchange{rw: rw, req: appendUpstreamRequest(nil, r, ip), head: r.Method == http.MethodHead})
+}
+
+// hopByHopHeaders only concern a single connection, they aren't forwarded, see RFC 9110, section 7.6.1.
+var hopByHopHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Connection", "Proxy-Authenticate", "Proxy-Authorization",
+	"Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+func isHopByHop(key string, header http.Header) bool {
+	for _, h := range hopByHopHeaders {
+		if key == h {
+			return true
+		}
+	}
+	// So are the fields listed by Connection.
+	return hasToken(header["Connection"], key)
+}
+
+// appendUpstreamRequest serializes r as it's sent upstream: without its hop-by-hop fields,
+// with a Content-Length as its body has been decoded already, and with the X-Forwarded-* fields.
+func appendUpstreamRequest(buf []byte, r *Request, ip string) []byte {
+	buf = append(buf, r.Method...)
+	buf = append(buf, ' ')
+	buf = append(buf, r.RequestURI...)
+	buf = append(buf, " HTTP/1.1\r\nHost: "...)
+	buf = append(buf, r.Host...)
+	buf = append(buf, "\r\n"...)
+	for key, values := range r.Header {
+		switch key {
+		case "Host", "Content-Length", "X-Forwarded-For", "X-Forwarded-Host", "X-Forwarded-Proto":
+			continue
+		}
+		if isHopByHop(key, r.Header) {
+			continue
+		}
+		for _, v := range values {
+			buf = append(buf, key...)
+			buf = append(buf, ": "...)
+			buf = append(buf, v...)
+			buf = append(buf, "\r\n"...)
+		}
+	}
+	buf = append(buf, "X-Forwarded-For: "...)
+	if prior := r.Header["X-Forwarded-For"]; len(prior) > 0 {
+		buf = append(buf, strings.Join(prior, ", ")...)
+		buf = append(buf, ", "...)
+	}
+	buf = append(buf, ip...)
+	buf = append(buf, "\r\nX-Forwarded-Host: "...)
+	buf = append(buf, r.Host...)
+	buf = append(buf, "\r\nX-Forwarded-Proto: http\r\n"...)
+	if len(r.Body) > 0 || r.Method == http.MethodPost || r.Method == http.MethodPut || r.Method == http.MethodPatch {
+		buf = append(buf, "Content-Length: "...)
+		buf = strconv.AppendInt(buf, int64(len(r.Body)), 10)
+		buf = append(buf, "\r\n"...)
+	}
+	buf = append(buf, "\r\n"...)
+	return append(buf, r.Body...)
+}
+
+func clientIP(remoteAddr string) string {
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		return host
+	}
+	return remoteAddr
+}
+
+// proxyExchange is a request on its way to an upstream, along with the response that awaits its answer.
+type proxyExchange struct {
+	rw    asyncWriter
+	req   []byte
+	head  bool
+	start int64
+}
+
+// respond passes the response of the upstream on, minus its hop-by-hop fields.
+func (ex *proxyExchange) respond(status int, header http.Header, body []byte) {
+	h := ex.rw.Header()
+	for key, values := range header {
+		// The server writes its own Server and Date fields.
+		if key != "Server" && key != "Date" && !isHopByHop(key, header) {
+			h[key] = values
+		}
+	}
+	if !ex.head && bodyAllowed(status) {
+		h.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	ex.rw.WriteHeader(status)
+	if len(body) > 0 && !ex.head {
+		_, _ = ex.rw.Write(body)
+	}
+	ex.rw.finish()
+}
+
+func (ex *proxyExchange) fail(status int) {
+	http.Error(ex.rw, strconv.Itoa(status)+" "+strings.ToLower(http.StatusText(status)), status)
+	ex.rw.finish()
+}
+
+// upstreamEngine runs the connections to the upstream servers on the event-loop of a gnet client,
+// connections are dialed by a worker as dialing blocks, and kept alive for later requests.
+type upstreamEngine struct {
+	gnet.BuiltinEventEngine
+
+	cli     *gnet.Client
+	workers *goroutine.Pool
+	timeout time.Duration
+	conns   sync.Map
+
+	// opening hands the first request of a new connection over between the worker dialing it and the event-loop
+	// registering it, whichever comes last sends it: a write queued as Dial returns may run ahead of the registration,
+	// and gnet drops the writes to connections it hasn't opened yet.
+	opening sync.Map
+}
+
+// newUpstreamEngine starts the client event-loop, a request not answered within timeout gets a 504.
+func newUpstreamEngine(timeout time.Duration, workers *goroutine.Pool) (*upstreamEngine, error) {
+	e := &upstreamEngine{workers: workers, timeout: timeout}
+	cli, err := gnet.NewClient(e, gnet.WithTicker(true), gnet.WithTCPNoDelay(gnet.TCPNoDelay))
+	if err != nil {
+		return nil, err
+	}
+	e.cli = cli
+	return e, cli.Start()
+}
+
+func (e *upstreamEngine) stop() {
+	_ = e.cli.Stop()
+}
+
+// send forwards ex to u, over an idle connection if there is one.
+func (e *upstreamEngine) send(u *upstream, ex *proxyExchange) {
+	atomic.AddInt64(&u.active, 1)
+	for uc := u.getIdle(); uc != nil; uc = u.getIdle() {
+		if uc.attach(ex) {
+			_ = uc.c.AsyncWrite(ex.req, nil)
+			return
+		}
+	}
+	if err := e.workers.Submit(func() { e.dial(u, ex) }); err != nil {
+		e.done(u, ex, http.StatusServiceUnavailable)
+	}
+}
+
+func (e *upstreamEngine) dial(u *upstream, ex *proxyExchange) {
+	c, err := e.cli.Dial("tcp", u.addr)
+	if err != nil {
+		log.Printf("failed to connect to upstream %s: %v\n", u.addr, err)
+		if u.checked {
+			u.setHealthy(false, err.Error())
+		}
+		e.done(u, ex, http.StatusBadGateway)
+		return
+	}
+	uc := newUpstreamConn(c, u)
+	uc.attach(ex)
+	e.conns.Store(uc, struct{}{})
+	if _, opened := e.opening.LoadOrStore(c, uc); opened {
+		e.opening.Delete(c)
+		c.SetContext(uc)
+		_ = c.AsyncWrite(ex.req, nil)
+	}
+}
+
+func (e *upstreamEngine) OnOpen(c gnet.Conn) ([]byte, gnet.Action) {
+	v, dialed := e.opening.LoadOrStore(c, struct{}{})
+	if !dialed {
+		return nil, gnet.None
+	}
+	e.opening.Delete(c)
+	uc := v.(*upstreamConn)
+	c.SetContext(uc)
+	if ex := uc.current(); ex != nil {
+		return ex.req, gnet.None
+	}
+	return nil, gnet.None
+}
+
+// done completes ex with an error status, unless it's 0 which means the response has been passed on already.
+func (e *upstreamEngine) done(u *upstream, ex *proxyExchange, status int) {
+	atomic.AddInt64(&u.active, -1)
+	if status != 0 {
+		ex.fail(status)
+	}
+}
+
+func (e *upstreamEngine) OnTraffic(c gnet.Conn) gnet.Action {
+	uc, ok := c.Context().(*upstreamConn)
+	if !ok {
+		return gnet.None
+	}
+	ex := uc.current()
+	if ex == nil {
+		// Upstreams don't get to speak unasked.
+		return gnet.Close
+	}
+	buf, _ := c.Peek(-1)
+	n, complete, err := uc.parse(buf, ex.head)
+	if n > 0 {
+		_, _ = c.Discard(n)
+	}
+	if err != nil {
+		log.Printf("bad response from upstream %s: %v\n", uc.u.addr, err)
+		return gnet.Close
+	}
+	if !complete {
+		return gnet.None
+	}
+	if uc.detach() == nil {
+		return gnet.None
+	}
+	ex.respond(uc.status, uc.header, uc.body)
+	e.done(uc.u, ex, 0)
+	keepAlive := uc.keepAlive
+	uc.reset()
+	if !keepAlive || !uc.u.healthy() || !uc.u.putIdle(uc) {
+		return gnet.Close
+	}
+	return gnet.None
+}
+
+func (e *upstreamEngine) OnClose(c gnet.Conn, _ error) gnet.Action {
+	e.opening.Delete(c)
+	uc, ok := c.Context().(*upstreamConn)
+	if !ok {
+		return gnet.None
+	}
+	e.conns.Delete(uc)
+	uc.u.removeIdle(uc)
+	uc.mu.Lock()
+	uc.closed = true
+	ex, timedOut := uc.ex, uc.timedOut
+	uc.ex = nil
+	uc.mu.Unlock()
+	switch {
+	case ex == nil:
+	case timedOut:
+		e.done(uc.u, ex, http.StatusGatewayTimeout)
+	case uc.headDone && uc.bodyLen < 0:
+		// The body of the response ends with the connection.
+		ex.respond(uc.status, uc.header, uc.body)
+		e.done(uc.u, ex, 0)
+	default:
+		e.done(uc.u, ex, http.StatusBadGateway)
+	}
+	return gnet.None
+}
+
+// OnTick closes the upstream connections whose response is overdue, their requests get a 504.
+func (e *upstreamEngine) OnTick() (time.Duration, gnet.Action) {
+	if e.timeout > 0 {
+		deadline := time.Now().Add(-e.timeout).UnixNano()
+		e.conns.Range(func(key, _ interface{}) bool {
+			uc := key.(*upstreamConn)
+			uc.mu.Lock()
+			if uc.ex != nil && uc.ex.start < deadline && !uc.timedOut {
+				uc.timedOut = true
+				_ = uc.c.Close(nil)
+			}
+			uc.mu.Unlock()
+			return true
+		})
+	}
+	return time.Second, gnet.None
+}
+
+// upstreamConn is the context of a connection to an upstream, it carries one exchange at a time.
+type upstreamConn struct {
+	c gnet.Conn
+	u *upstream
+
+	// ex is set from the event-loops of the server and cleared from the one of the client.
+	mu       sync.Mutex
+	ex       *proxyExchange
+	closed   bool
+	timedOut bool
+
+	// The response being parsed, only accessed on the event-loop of the client.
+	headDone  bool
+	status    int
+	header    http.Header
+	keepAlive bool
+	bodyLen   int64
+	isChunked bool
+	chunked   chunkedDecoder
+	body      []byte
+}
+
+func newUpstreamConn(c gnet.Conn, u *upstream) *upstreamConn {
+	uc := &upstreamConn{c: c, u: u}
+	uc.reset()
+	return uc
+}
+
+func (uc *upstreamConn) reset() {
+	uc.headDone, uc.status, uc.header, uc.keepAlive = false, 0, nil, false
+	uc.bodyLen, uc.isChunked, uc.body = 0, false, uc.body[:0]
+}
+
+// attach makes ex the exchange of the connection, it fails if the connection has been closed meanwhile.
+func (uc *upstreamConn) attach(ex *proxyExchange) bool {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+	if uc.closed {
+		return false
+	}
+	ex.start = time.Now().UnixNano()
+	uc.ex = ex
+	return true
+}
+
+func (uc *upstreamConn) current() *proxyExchange {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+	return uc.ex
+}
+
+func (uc *upstreamConn) detach() *proxyExchange {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+	ex := uc.ex
+	uc.ex = nil
+	return ex
+}
+
+// parse parses the response at the start of buf and returns how many bytes it consumed, complete is set
+// once the response is, head tells the response to a HEAD request, which has no body.
+func (uc *upstreamConn) parse(buf []byte, head bool) (n int, complete bool, err error) {
+	for !uc.headDone {
+		m, err := uc.parseHead(buf[n:])
+		if err != nil || m == 0 {
+			return n, false, err
+		}
+		n += m
+		// Interim responses are dropped, they're about the request that has been sent already.
+		if uc.status < 200 {
+			continue
+		}
+		uc.headDone = true
+		switch {
+		case head || !bodyAllowed(uc.status):
+		case hasToken(uc.header["Transfer-Encoding"], "chunked"):
+			uc.isChunked = true
+			uc.chunked.reset(maxResponseBody, nil)
+		case uc.header.Get("Content-Length") != "":
+			length, ok := parseContentLength([]byte(uc.header.Get("Content-Length")))
+			if !ok || length > maxResponseBody {
+				return n, false, errBadResponse
+			}
+			uc.bodyLen = length
+		default:
+			uc.bodyLen, uc.keepAlive = -1, false
+		}
+	}
+	rest := buf[n:]
+	switch {
+	case uc.isChunked:
+		m, err := uc.chunked.feed(rest)
+		n += m
+		if err != nil || !uc.chunked.done() {
+			return n, false, err
+		}
+		uc.body = append(uc.body, uc.chunked.body...)
+		return n, true, nil
+	case uc.bodyLen < 0:
+		if len(uc.body)+len(rest) > maxResponseBody {
+			return n, false, errBadResponse
+		}
+		uc.body = append(uc.body, rest...)
+		return len(buf), false, nil
+	default:
+		if need := int(uc.bodyLen) - len(uc.body); len(rest) > need {
+			rest = rest[:need]
+		}
+		uc.body = append(uc.body, rest...)
+		return n + len(rest), int64(len(uc.body)) == uc.bodyLen, nil
+	}
+}
+
+// parseHead parses a status line and header fields, it returns 0 until they have fully arrived.
+func (uc *upstreamConn) parseHead(data []byte) (int, error) {
+	end := bytes.Index(data, []byte("\r\n\r\n"))
+	if end < 0 {
+		if len(data) > maxResponseHead {
+			return 0, errBadResponse
+		}
+		return 0, nil
+	}
+	lines := bytes.Split(data[:end], []byte("\r\n"))
+	status := lines[0]
+	if len(status) < 12 || !bytes.HasPrefix(status, []byte("HTTP/1.")) || status[8] != ' ' {
+		return 0, errBadResponse
+	}
+	code, err := strconv.Atoi(string(status[9:12]))
+	if err != nil || code < 100 || code > 999 {
+		return 0, errBadResponse
+	}
+	header := make(http.Header)
+	for _, line := range lines[1:] {
+		i := bytes.IndexByte(line, ':')
+		if i <= 0 || !isToken(line[:i]) {
+			return 0, errBadResponse
+		}
+		header.Add(string(line[:i]), string(bytes.TrimSpace(line[i+1:])))
+	}
+	uc.status, uc.header = code, header
+	if status[7] == '0' {
+		uc.keepAlive = hasToken(header["Connection"], "keep-alive")
+	} else {
+		uc.keepAlive = !hasToken(header["Connection"], "close")
+	}
+	return end + 4, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Request is a parsed HTTP request as seen by handlers.
+//
+// Body is the complete request body, decoded already if it was sent chunked. It references a buffer
+// of the connection and is only valid until ServeHTTP returns, handlers that keep it around must copy it.
+// It's empty for a StreamHandler, whose body has gone to Sink.
+type Request struct {
+	Method        string
+	RequestURI    string
+	Path          string
+	RawQuery      string
+	Proto         string
+	Host          string
+	Header        http.Header
+	ContentLength int64
+	Body          []byte
+	// Sink is where the body has been written for a StreamHandler, see StreamHandler.OpenBody.
+	Sink BodySink
+	// Trailer holds the trailer fields sent after a chunked body.
+	Trailer    http.Header
+	RemoteAddr string
+	Params     Params
+	// Close reports whether the client wants the connection closed after the response.
+	Close bool
+
+	query url.Values
+	hc    *httpCodec
+}
+
+// newRequest builds a Request out of the parser state of the request just parsed, whose head is at the start of data.
+// header is reused across the requests of a connection to save allocations.
+func newRequest(p *requestParser, data []byte, header http.Header, body []byte, remoteAddr string) *Request {
+	for k := range header {
+		delete(header, k)
+	}
+	for _, f := range p.headers {
+		key := http.CanonicalHeaderKey(string(f.name.of(data)))
+		header[key] = append(header[key], string(f.value.of(data)))
+	}
+	r := &Request{
+		Method:        string(p.method.of(data)),
+		RequestURI:    string(p.target.of(data)),
+		Proto:         string(p.version.of(data)),
+		Host:          string(p.host.of(data)),
+		Header:        header,
+		ContentLength: int64(len(body)),
+		Body:          body,
+		RemoteAddr:    remoteAddr,
+	}
+	r.Close = wantsClose(r.Proto, header)
+	r.Path, r.RawQuery = splitTarget(r.RequestURI)
+	return r
+}
+
+// BodyReader returns a reader over Body, for handlers that consume the body as a stream.
+func (r *Request) BodyReader() io.Reader {
+	return bytes.NewReader(r.Body)
+}
+
+// Query parses RawQuery and returns the corresponding values, malformed pairs are silently discarded.
+func (r *Request) Query() url.Values {
+	if r.query == nil {
+		r.query, _ = url.ParseQuery(r.RawQuery)
+	}
+	return r.query
+}
+
+// splitTarget splits a request target into its unescaped path and its query.
+func splitTarget(uri string) (path, query string) {
+	path, query = splitQuery(uri)
+	if p, err := url.PathUnescape(path); err == nil {
+		path = p
+	}
+	return path, query
+}
+
+func splitQuery(uri string) (path, query string) {
+	if i := strings.IndexByte(uri, '?'); i >= 0 {
+		return uri[:i], uri[i+1:]
+	}
+	return uri, ""
+}
+
+// wantsClose reports whether a request asks for the connection to be closed after its response:
+// HTTP/1.1 connections persist unless "Connection: close" is sent, HTTP/1.0 ones are closed
+// unless "Connection: keep-alive" is sent, see RFC 9112, section 9.3.
+func wantsClose(proto string, header http.Header) bool {
+	if proto == "HTTP/1.0" {
+		return !hasToken(header["Connection"], "keep-alive")
+	}
+	return hasToken(header["Connection"], "close")
+}
+
+// hasToken reports whether token is one of the comma-separated elements of a header, case-insensitively.
+func hasToken(values []string, token string) bool {
+	for _, v := range values {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"sync/atomic"
+)
+
+// maxRequestIDLen bounds the IDs accepted from clients, longer ones are replaced.
+const maxRequestIDLen = 128
+
+// requestIDs generates request IDs unique across restarts: a random prefix drawn at startup and a counter.
+type requestIDs struct {
+	prefix string
+	n      uint64
+}
+
+func newRequestIDs() *requestIDs {
+	var b [6]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return &requestIDs{prefix: hex.EncodeToString(b[:]) + "-"}
+}
+
+func (g *requestIDs) next() string {
+	return g.prefix + strconv.FormatUint(atomic.AddUint64(&g.n, 1), 16)
+}
+
+// validRequestID reports whether a client-supplied ID is fit to be logged and passed on: short and made
+// of visible ASCII characters, quotes and backslashes excluded.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if c := id[i]; c <= ' ' || c >= 0x7f || c == '"' || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+// requestID returns a middleware giving every request an ID: the X-Request-ID it came with, or a new one.
+// The ID is set in the X-Request-ID header of the request, for the handlers, the access log and the upstreams
+// of the reverse proxy, and in that of the response, so that the client can tell which request it was.
+// To have it in the access log, the middleware must come before the access log one.
+func requestID() Middleware {
+	ids := newRequestIDs()
+	return func(next Handler) Handler {
+		return HandlerFunc(func(w ResponseWriter, r *Request) {
+			id := r.Header.Get("X-Request-Id")
+			if !validRequestID(id) {
+				id = ids.next()
+				r.Header.Set("X-Request-Id", id)
+			}
+			w.Header().Set("X-Request-Id", id)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
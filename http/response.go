@@ -0,0 +1,260 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/panjf2000/gnet/v2"
+)
+
+// ResponseWriter is used by a handler to construct an HTTP response, it mirrors http.ResponseWriter.
+//
+// The response is buffered and sent with a computed Content-Length once the handler returns,
+// unless the handler calls Flush first: then the headers go out right away and, without an explicit
+// Content-Length, the body is sent with chunked Transfer-Encoding.
+type ResponseWriter interface {
+	Header() http.Header
+	Write(p []byte) (int, error)
+	WriteHeader(statusCode int)
+	Flush()
+}
+
+// response is the ResponseWriter of a single request, it serializes into out,
+// which is the output buffer of the connection unless the response has been detached.
+type response struct {
+	hc          *httpCodec
+	req         *Request
+	out         *[]byte
+	header      http.Header
+	status      int
+	wroteHeader bool
+	chunked     bool
+	body        []byte
+
+	// close is set when the connection is to be closed once the response is sent.
+	close bool
+
+	// detached is set on a response handed over to another goroutine, which finishes it through async,
+	// a response that lives outside the event-loop and flushes with AsyncWrite.
+	detached bool
+	async    gnet.Conn
+}
+
+func (w *response) reset(hc *httpCodec, req *Request) {
+	for k := range w.header {
+		delete(w.header, k)
+	}
+	if w.header == nil {
+		w.header = make(http.Header)
+	}
+	*w = response{hc: hc, req: req, out: &hc.buf, header: w.header, body: w.body[:0]}
+}
+
+// asyncWriter is a ResponseWriter handed over to another goroutine, which completes it with finish,
+// or with fail if the handler panicked.
+type asyncWriter interface {
+	ResponseWriter
+	finish()
+	fail()
+	codec() *httpCodec
+	// backlog returns how many bytes of the response are held in memory waiting to go out, or an error
+	// once they can't go out anymore. It must be called on the event-loop of c.
+	backlog(c gnet.Conn) (int, error)
+}
+
+// detacher is implemented by the ResponseWriters that can be handed over to another goroutine.
+type detacher interface {
+	detachWriter() asyncWriter
+}
+
+func (w *response) detachWriter() asyncWriter {
+	return w.detach()
+}
+
+func (w *response) codec() *httpCodec {
+	return w.hc
+}
+
+func (w *response) backlog(c gnet.Conn) (int, error) {
+	return c.OutboundBuffered(), nil
+}
+
+// detach hands the response over to another goroutine: the returned response carries the status and headers
+// set so far, has buffers of its own and is sent with AsyncWrite. The connection serves no further request
+// until it is finished, so that pipelined responses keep their order.
+func (w *response) detach() *response {
+	w.detached = true
+	w.hc.busy = true
+	return &response{hc: w.hc, req: w.req, out: new([]byte), header: w.header.Clone(), status: w.status, async: w.hc.conn}
+}
+
+func (w *response) Header() http.Header {
+	return w.header
+}
+
+func (w *response) WriteHeader(statusCode int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = statusCode
+}
+
+func (w *response) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !bodyAllowed(w.status) {
+		return 0, http.ErrBodyNotAllowed
+	}
+	w.body = append(w.body, p...)
+	return len(p), nil
+}
+
+func (w *response) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush sends the headers and whatever body has been written so far.
+func (w *response) Flush() {
+	if !w.wroteHeader {
+		if w.header.Get("Content-Length") == "" && bodyAllowed(w.statusCode()) {
+			if w.req.Proto == "HTTP/1.0" {
+				// HTTP/1.0 clients don't know about chunked encoding, the body ends with the connection.
+				w.close = true
+			} else {
+				w.chunked = true
+				w.header.Set("Transfer-Encoding", "chunked")
+			}
+		}
+		w.writeHeader()
+	}
+	w.writeBody()
+	if w.async != nil {
+		w.send(nil)
+	}
+}
+
+// finish completes the response after the handler has returned.
+func (w *response) finish() {
+	if w.detached {
+		return
+	}
+	if !w.wroteHeader {
+		if bodyAllowed(w.statusCode()) && w.header.Get("Content-Length") == "" {
+			w.header.Set("Content-Length", strconv.Itoa(len(w.body)))
+		}
+		w.writeHeader()
+	}
+	w.writeBody()
+	if w.chunked && w.req.Method != http.MethodHead {
+		*w.out = append(*w.out, "0\r\n\r\n"...)
+	}
+	if w.async == nil {
+		w.hc.closing = w.close
+		return
+	}
+	hc, closing := w.hc, w.close
+	w.send(func(c gnet.Conn) error {
+		// Back on the event-loop, serve the requests that were held back meanwhile.
+		hc.busy = false
+		hc.closing = closing
+		return hc.srv.resume(c)
+	})
+}
+
+// fail completes the response of a handler that panicked: with a 500 if nothing has been sent yet,
+// by closing the connection otherwise, as there is no other way to tell the client.
+func (w *response) fail() {
+	if w.wroteHeader {
+		_ = w.hc.conn.Close(nil)
+		return
+	}
+	for k := range w.header {
+		delete(w.header, k)
+	}
+	w.status, w.body = 0, w.body[:0]
+	w.close = true
+	http.Error(w, "500 internal server error", http.StatusInternalServerError)
+	w.finish()
+}
+
+// send passes the serialized bytes of an async response on to the event-loop of its connection.
+func (w *response) send(callback gnet.AsyncCallback) {
+	data := *w.out
+	*w.out = nil
+	if len(data) == 0 && callback == nil {
+		return
+	}
+	_ = w.async.AsyncWrite(data, callback)
+}
+
+func (w *response) statusCode() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+func (w *response) writeHeader() {
+	w.wroteHeader = true
+	status := w.statusCode()
+	if bodyAllowed(status) && w.header.Get("Content-Type") == "" && len(w.body) > 0 {
+		w.header.Set("Content-Type", http.DetectContentType(w.body))
+	}
+	// A connection switching protocols is no longer managed as an HTTP one.
+	max := w.hc.srv.maxRequests
+	if status != http.StatusSwitchingProtocols && (w.req.Close || (max > 0 && w.hc.requests >= max) ||
+		hasToken(w.header["Connection"], "close")) {
+		w.close = true
+	}
+	if w.close {
+		w.header.Set("Connection", "close")
+	} else if w.req.Proto == "HTTP/1.0" {
+		w.header.Set("Connection", "keep-alive")
+	}
+	buf := *w.out
+	buf = append(buf, "HTTP/1.1 "...)
+	buf = strconv.AppendInt(buf, int64(status), 10)
+	buf = append(buf, ' ')
+	buf = append(buf, http.StatusText(status)...)
+	buf = append(buf, "\r\nServer: gnet\r\nDate: "...)
+	buf = append(buf, w.hc.srv.date.get()...)
+	buf = append(buf, "\r\n"...)
+	for key, values := range w.header {
+		for _, v := range values {
+			buf = append(buf, key...)
+			buf = append(buf, ": "...)
+			buf = append(buf, v...)
+			buf = append(buf, "\r\n"...)
+		}
+	}
+	buf = append(buf, "\r\n"...)
+	*w.out = buf
+}
+
+func (w *response) writeBody() {
+	if len(w.body) == 0 {
+		return
+	}
+	if w.req.Method != http.MethodHead {
+		buf := *w.out
+		if w.chunked {
+			buf = strconv.AppendInt(buf, int64(len(w.body)), 16)
+			buf = append(buf, "\r\n"...)
+			buf = append(buf, w.body...)
+			buf = append(buf, "\r\n"...)
+		} else {
+			buf = append(buf, w.body...)
+		}
+		*w.out = buf
+	}
+	w.body = w.body[:0]
+}
+
+// bodyAllowed reports whether a given response status code permits a body, see RFC 9110, section 6.4.1.
+func bodyAllowed(status int) bool {
+	if status >= 100 && status <= 199 {
+		return false
+	}
+	return status != http.StatusNoContent && status != http.StatusNotModified
+}
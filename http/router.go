@@ -0,0 +1,216 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Param is a single URL parameter, consisting of a key and a value.
+type Param struct {
+	Key   string
+	Value string
+}
+
+// Params holds the values captured by the named parameters and the wildcard of a route.
+type Params []Param
+
+// ByName returns the value of the first parameter named name, or "" if there is none.
+func (ps Params) ByName(name string) string {
+	for _, p := range ps {
+		if p.Key == name {
+			return p.Value
+		}
+	}
+	return ""
+}
+
+// node is a path segment in the routing tree, static children take precedence over
+// a named parameter (":name"), which takes precedence over a trailing wildcard ("*name").
+type node struct {
+	static       map[string]*node
+	param        *node
+	paramName    string
+	wildcard     *node
+	wildcardName string
+	handlers     map[string]Handler
+}
+
+// router dispatches requests by method and path, answering 404 when no route matches the path
+// and 405 when a route matches the path but not the method.
+type router struct {
+	root node
+	// bodyPolicies counts the routes having a say in how request bodies are received, see hasBodyPolicy.
+	bodyPolicies int
+
+	// NotFound and MethodNotAllowed are invoked when no route matches, they may be replaced.
+	NotFound         Handler
+	MethodNotAllowed Handler
+}
+
+func newRouter() *router {
+	return &router{
+		NotFound:         HandlerFunc(notFound),
+		MethodNotAllowed: HandlerFunc(methodNotAllowed),
+	}
+}
+
+// Handle registers handler for the method and pattern, patterns are absolute paths whose segments
+// may be static, ":name" to match exactly one segment, or "*name" as the last segment to match the rest.
+// It panics on a malformed pattern or a conflicting registration, like http.ServeMux does.
+func (rt *router) Handle(method, pattern string, handler Handler) {
+	if !strings.HasPrefix(pattern, "/") {
+		panic("router: pattern must begin with '/' in " + pattern)
+	}
+	n := &rt.root
+	segments := splitPath(pattern)
+	for i, seg := range segments {
+		switch {
+		case strings.HasPrefix(seg, ":"):
+			name := seg[1:]
+			if name == "" {
+				panic("router: empty parameter name in " + pattern)
+			}
+			if n.param == nil {
+				n.param, n.paramName = new(node), name
+			} else if n.paramName != name {
+				panic("router: parameter :" + name + " conflicts with :" + n.paramName + " in " + pattern)
+			}
+			n = n.param
+		case strings.HasPrefix(seg, "*"):
+			name := seg[1:]
+			if name == "" || i != len(segments)-1 {
+				panic("router: wildcard must be named and be the last segment in " + pattern)
+			}
+			if n.wildcard == nil {
+				n.wildcard, n.wildcardName = new(node), name
+			} else if n.wildcardName != name {
+				panic("router: wildcard *" + name + " conflicts with *" + n.wildcardName + " in " + pattern)
+			}
+			n = n.wildcard
+		default:
+			if n.static == nil {
+				n.static = make(map[string]*node)
+			}
+			child, ok := n.static[seg]
+			if !ok {
+				child = new(node)
+				n.static[seg] = child
+			}
+			n = child
+		}
+	}
+	if n.handlers == nil {
+		n.handlers = make(map[string]Handler)
+	}
+	if _, ok := n.handlers[method]; ok {
+		panic("router: multiple registrations for " + method + " " + pattern)
+	}
+	n.handlers[method] = handler
+	if hasBodyPolicy(handler) {
+		rt.bodyPolicies++
+	}
+}
+
+// HandleFunc registers the handler function for the method and pattern.
+func (rt *router) HandleFunc(method, pattern string, handler func(ResponseWriter, *Request)) {
+	rt.Handle(method, pattern, HandlerFunc(handler))
+}
+
+// ServeHTTP dispatches r to the handler of the matching route, HEAD falls back to the GET handler.
+func (rt *router) ServeHTTP(w ResponseWriter, r *Request) {
+	n, params := rt.root.match(splitPath(r.Path), nil)
+	if n == nil {
+		rt.NotFound.ServeHTTP(w, r)
+		return
+	}
+	h, ok := n.handlers[r.Method]
+	if !ok && r.Method == http.MethodHead {
+		h, ok = n.handlers[http.MethodGet]
+	}
+	if !ok {
+		w.Header().Set("Allow", n.allowed())
+		rt.MethodNotAllowed.ServeHTTP(w, r)
+		return
+	}
+	r.Params = params
+	h.ServeHTTP(w, r)
+}
+
+// lookup returns the handler of the route matching method and path, or nil if there is none.
+func (rt *router) lookup(method, path string) Handler {
+	n, _ := rt.root.match(splitPath(path), nil)
+	if n == nil {
+		return nil
+	}
+	h, ok := n.handlers[method]
+	if !ok && method == http.MethodHead {
+		h = n.handlers[http.MethodGet]
+	}
+	return h
+}
+
+// match walks the tree depth-first and backtracks when a more specific branch leads nowhere,
+// so "/users/new" can live next to "/users/:id".
+func (n *node) match(segments []string, params Params) (*node, Params) {
+	if len(segments) == 0 {
+		if n.handlers != nil {
+			return n, params
+		}
+		// A wildcard also matches an empty remainder, e.g. "/static/" for "/static/*filepath".
+		if n.wildcard != nil && n.wildcard.handlers != nil {
+			return n.wildcard, append(params, Param{Key: n.wildcardName})
+		}
+		return nil, nil
+	}
+	seg := segments[0]
+	if child, ok := n.static[seg]; ok {
+		if found, ps := child.match(segments[1:], params); found != nil {
+			return found, ps
+		}
+	}
+	if n.param != nil && seg != "" {
+		if found, ps := n.param.match(segments[1:], append(params, Param{Key: n.paramName, Value: seg})); found != nil {
+			return found, ps
+		}
+	}
+	if n.wildcard != nil && n.wildcard.handlers != nil {
+		return n.wildcard, append(params, Param{Key: n.wildcardName, Value: strings.Join(segments, "/")})
+	}
+	return nil, nil
+}
+
+func (n *node) allowed() string {
+	methods := make([]string, 0, len(n.handlers)+1)
+	for method := range n.handlers {
+		methods = append(methods, method)
+	}
+	if _, ok := n.handlers[http.MethodGet]; ok {
+		if _, ok = n.handlers[http.MethodHead]; !ok {
+			methods = append(methods, http.MethodHead)
+		}
+	}
+	sort.Strings(methods)
+	return strings.Join(methods, ", ")
+}
+
+// splitPath turns "/a/b/" into ["a", "b", ""], the trailing empty segment keeps "/a/b/" distinct from "/a/b".
+func splitPath(path string) []string {
+	path = strings.TrimPrefix(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+func notFound(w ResponseWriter, _ *Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusNotFound)
+	_, _ = w.Write([]byte("404 page not found\n"))
+}
+
+func methodNotAllowed(w ResponseWriter, _ *Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusMethodNotAllowed)
+	_, _ = w.Write([]byte("405 method not allowed\n"))
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errInvalidEventName is returned for an event type that would end its field early, as a line break does.
+var errInvalidEventName = errors.New("invalid event name")
+
+// sseEvent is a published event, serialized once for all the subscribers.
+type sseEvent struct {
+	id   uint64
+	data []byte
+}
+
+// sseSubscriber is a client connection holding an event stream open.
+type sseSubscriber struct {
+	rw     asyncWriter
+	closed <-chan struct{}
+}
+
+// sseBroker serves Server-Sent Events, see https://html.spec.whatwg.org/multipage/server-sent-events.html.
+// As a Handler it keeps the response open as a text/event-stream, events published to the broker are written
+// to every subscriber, and the event-loops send them out with AsyncWrite. Events get increasing IDs, and the
+// most recent ones are kept so that a client reconnecting with Last-Event-ID gets those it missed.
+type sseBroker struct {
+	retry     time.Duration
+	heartbeat time.Duration
+	maxReplay int
+
+	mu     sync.Mutex
+	lastID uint64
+	replay []sseEvent
+	subs   map[*sseSubscriber]struct{}
+
+	// stop ends the heartbeat, which closes done once it has.
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// newSSEBroker returns a broker keeping the maxReplay most recent events, it tells clients to reconnect after retry
+// and writes a comment to the idle streams every heartbeat, which keeps proxies from timing them out and lets the
+// broker notice the clients that are gone.
+func newSSEBroker(retry, heartbeat time.Duration, maxReplay int) *sseBroker {
+	b := &sseBroker{
+		retry:     retry,
+		heartbeat: heartbeat,
+		maxReplay: maxReplay,
+		subs:      make(map[*sseSubscriber]struct{}),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	if heartbeat > 0 {
+		go b.run()
+	} else {
+		close(b.done)
+	}
+	return b
+}
+
+// close stops the heartbeat, the broker must not be used anymore.
+func (b *sseBroker) close() {
+	b.once.Do(func() {
+		close(b.stop)
+		<-b.done
+	})
+}
+
+func (b *sseBroker) ServeHTTP(w ResponseWriter, r *Request) {
+	var rw asyncWriter
+	if d, ok := w.(detacher); ok {
+		rw = d.detachWriter()
+	}
+	if rw == nil {
+		http.Error(w, "500 internal server error", http.StatusInternalServerError)
+		return
+	}
+	h := rw.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	rw.WriteHeader(http.StatusOK)
+
+	var buf []byte
+	if b.retry > 0 {
+		buf = append(buf, "retry: "...)
+		buf = strconv.AppendInt(buf, int64(b.retry/time.Millisecond), 10)
+		buf = append(buf, "\n\n"...)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// A reconnecting client gets the events it missed, those that are still kept anyway.
+	if last, err := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64); err == nil && last <= b.lastID {
+		for _, ev := range b.replay {
+			if ev.id > last {
+				buf = append(buf, ev.data...)
+			}
+		}
+	}
+	_, _ = rw.Write(buf)
+	rw.Flush()
+	b.subs[&sseSubscriber{rw: rw, closed: rw.codec().closed}] = struct{}{}
+}
+
+// Publish sends an event to every subscriber and returns its ID, name is the event type, empty for
+// the default "message" type, and each line of data makes a data field. A name with a line break
+// is rejected with errInvalidEventName.
+func (b *sseBroker) Publish(name, data string) (uint64, error) {
+	if strings.ContainsAny(name, "\r\n") {
+		return 0, errInvalidEventName
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lastID++
+	ev := sseEvent{id: b.lastID, data: appendEvent(nil, b.lastID, name, data)}
+	if b.maxReplay > 0 {
+		if len(b.replay) == b.maxReplay {
+			copy(b.replay, b.replay[1:])
+			b.replay = b.replay[:len(b.replay)-1]
+		}
+		b.replay = append(b.replay, ev)
+	}
+	b.broadcast(ev.data)
+	return ev.id, nil
+}
+
+// broadcast writes data to the subscribers and drops those whose connection has been closed, b.mu must be held.
+func (b *sseBroker) broadcast(data []byte) {
+	for s := range b.subs {
+		select {
+		case <-s.closed:
+			delete(b.subs, s)
+			// Completes the response for the middlewares, the bytes go nowhere.
+			s.rw.finish()
+			continue
+		default:
+		}
+		_, _ = s.rw.Write(data)
+		s.rw.Flush()
+	}
+}
+
+func (b *sseBroker) run() {
+	defer close(b.done)
+	ticker := time.NewTicker(b.heartbeat)
+	defer ticker.Stop()
+	comment := []byte(":\n\n")
+	for {
+		select {
+		case <-ticker.C:
+			b.mu.Lock()
+			b.broadcast(comment)
+			b.mu.Unlock()
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+func appendEvent(buf []byte, id uint64, name, data string) []byte {
+	buf = append(buf, "id: "...)
+	buf = strconv.AppendUint(buf, id, 10)
+	buf = append(buf, '\n')
+	if name != "" {
+		buf = append(buf, "event: "...)
+		buf = append(buf, name...)
+		buf = append(buf, '\n')
+	}
+	// Event streams end lines with CRLF, LF or a lone CR alike.
+	data = strings.Replace(strings.Replace(data, "\r\n", "\n", -1), "\r", "\n", -1)
+	for _, line := range strings.Split(data, "\n") {
+		buf = append(buf, "data: "...)
+		buf = append(buf, line...)
+		buf = append(buf, '\n')
+	}
+	return append(buf, '\n')
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAppendEvent(t *testing.T) {
+	tests := []struct {
+		name, data string
+		want       string
+	}{
+		{"", "hello", "id: 7\ndata: hello\n\n"},
+		{"update", "a\r\nb\nc\rd", "id: 7\nevent: update\ndata: a\ndata: b\ndata: c\ndata: d\n\n"},
+		{"", "forged\rid: 99\rretry: 1", "id: 7\ndata: forged\ndata: id: 99\ndata: retry: 1\n\n"},
+		{"", "", "id: 7\ndata: \n\n"},
+	}
+	for _, tt := range tests {
+		if got := string(appendEvent(nil, 7, tt.name, tt.data)); got != tt.want {
+			t.Errorf("appendEvent(%q, %q) = %q, want %q", tt.name, tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestPublishEventName(t *testing.T) {
+	b := newSSEBroker(0, 0, 0)
+	defer b.close()
+	for _, name := range []string{"x\ndata: forged", "x\rid: 99", "x\r\n"} {
+		if _, err := b.Publish(name, "data"); err != errInvalidEventName {
+			t.Errorf("Publish(%q) = %v, want %v", name, err, errInvalidEventName)
+		}
+	}
+	if id, err := b.Publish("update", "data"); id != 1 || err != nil {
+		t.Errorf("Publish(\"update\") = %d, %v", id, err)
+	}
+}
+
+func TestBrokerClose(t *testing.T) {
+	b := newSSEBroker(0, time.Millisecond, 0)
+	time.Sleep(5 * time.Millisecond)
+	b.close()
+	select {
+	case <-b.done:
+	default:
+		t.Fatal("heartbeat still running after close")
+	}
+	b.close()
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// fileUpload is a StreamHandler storing request bodies in files of a directory as they arrive, so that
+// uploads don't sit in memory whatever their size. Files are written on the event-loop, which suits disks
+// keeping up with the network; a slower store would rather hand the chunks over to a goroutine.
+type fileUpload struct {
+	dir     string
+	maxSize int64
+}
+
+// receiveFiles returns a handler storing request bodies of up to maxSize bytes in new files under dir,
+// 0 meaning no limit, it answers with the name of the file.
+func receiveFiles(dir string, maxSize int64) Handler {
+	return &fileUpload{dir: dir, maxSize: maxSize}
+}
+
+// fileSink writes a body to its file, the file is removed if the body isn't complete.
+type fileSink struct {
+	f *os.File
+}
+
+func (s *fileSink) Write(p []byte) (int, error) {
+	return s.f.Write(p)
+}
+
+func (s *fileSink) Abort(err error) {
+	_ = s.f.Close()
+	_ = os.Remove(s.f.Name())
+	log.Printf("upload to %s aborted: %v\n", s.f.Name(), err)
+}
+
+func (fu *fileUpload) OpenBody(r *Request) (BodySink, int64, error) {
+	f, err := ioutil.TempFile(fu.dir, "upload-")
+	if err != nil {
+		return nil, 0, err
+	}
+	return &fileSink{f}, fu.maxSize, nil
+}
+
+func (fu *fileUpload) ServeHTTP(w ResponseWriter, r *Request) {
+	s, ok := r.Sink.(*fileSink)
+	if !ok {
+		http.Error(w, "400 bad request: empty body", http.StatusBadRequest)
+		return
+	}
+	if err := s.f.Close(); err != nil {
+		_ = os.Remove(s.f.Name())
+		http.Error(w, "500 internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	_, _ = fmt.Fprintf(w, "stored %d bytes in %s\n", r.ContentLength, filepath.Base(s.f.Name()))
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/gobwas/ws"
+	"github.com/panjf2000/gnet/v2"
+)
+
+// websocketGUID is appended to the key of a handshake to compute the accept value, see RFC 6455, section 1.3.
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// wsMaxMessage bounds the messages of a connection whose server has no max body size.
+const wsMaxMessage = 64 << 20
+
+var errWebSocketClosed = errors.New("websocket closed")
+
+// wsHandler handles a complete message received on a WebSocket connection, payload is only valid
+// until it returns. It runs on the event-loop of the connection.
+type wsHandler func(wc *wsConn, op ws.OpCode, payload []byte)
+
+// upgradeWebSocket returns a handler that switches the connection of a valid WebSocket handshake over to h,
+// once the 101 response is sent the connection speaks WebSocket rather than HTTP.
+func upgradeWebSocket(h wsHandler) Handler {
+	return HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.Method != http.MethodGet || r.Proto != "HTTP/1.1" || !hasToken(r.Header["Upgrade"], "websocket") ||
+			!hasToken(r.Header["Connection"], "upgrade") {
+			w.Header().Set("Upgrade", "websocket")
+			http.Error(w, "426 upgrade required", http.StatusUpgradeRequired)
+			return
+		}
+		if r.Header.Get("Sec-WebSocket-Version") != "13" {
+			w.Header().Set("Sec-WebSocket-Version", "13")
+			http.Error(w, "426 unsupported websocket version", http.StatusUpgradeRequired)
+			return
+		}
+		key := r.Header.Get("Sec-WebSocket-Key")
+		if k, err := base64.StdEncoding.DecodeString(key); err != nil || len(k) != 16 {
+			http.Error(w, "400 bad request: invalid Sec-WebSocket-Key", http.StatusBadRequest)
+			return
+		}
+		sum := sha1.Sum([]byte(key + websocketGUID))
+		w.Header().Set("Upgrade", "websocket")
+		w.Header().Set("Connection", "Upgrade")
+		w.Header().Set("Sec-WebSocket-Accept", base64.StdEncoding.EncodeToString(sum[:]))
+		w.WriteHeader(http.StatusSwitchingProtocols)
+		r.hc.upgrade = &wsConn{c: r.hc.conn, handler: h, maxMessage: r.hc.maxBody}
+	})
+}
+
+// wsConn is the context of a connection that has been upgraded to WebSocket, see RFC 6455, section 5.
+type wsConn struct {
+	c          gnet.Conn
+	handler    wsHandler
+	maxMessage int64
+
+	// A fragmented message being reassembled.
+	fragmented bool
+	fragOp     ws.OpCode
+	fragments  []byte
+}
+
+// WriteMessage sends a message in a single frame, it must be called on the event-loop of the connection,
+// that is from the wsHandler.
+func (wc *wsConn) WriteMessage(op ws.OpCode, payload []byte) error {
+	_, err := wc.c.Write(appendFrame(nil, op, payload))
+	return err
+}
+
+// decode handles every complete frame at the start of buf and returns how many bytes it consumed,
+// errWebSocketClosed means the connection is to be closed.
+func (wc *wsConn) decode(buf []byte) (consumed int, err error) {
+	for {
+		n, err := wc.decodeFrame(buf[consumed:])
+		consumed += n
+		if err == errIncompleteRequest {
+			return consumed, nil
+		}
+		if err != nil {
+			return consumed, err
+		}
+	}
+}
+
+func (wc *wsConn) decodeFrame(b []byte) (int, error) {
+	if len(b) < 2 {
+		return 0, errIncompleteRequest
+	}
+	fin, op := b[0]&0x80 != 0, ws.OpCode(b[0]&0x0f)
+	if b[0]&0x70 != 0 {
+		return 0, wc.fail(ws.StatusProtocolError, "reserved bits set")
+	}
+	// Frames sent by clients must be masked.
+	if b[1]&0x80 == 0 {
+		return 0, wc.fail(ws.StatusProtocolError, "unmasked frame")
+	}
+	length, hl := int64(b[1]&0x7f), 2
+	switch length {
+	case 126:
+		if len(b) < 4 {
+			return 0, errIncompleteRequest
+		}
+		length, hl = int64(binary.BigEndian.Uint16(b[2:])), 4
+	case 127:
+		if len(b) < 10 {
+			return 0, errIncompleteRequest
+		}
+		u := binary.BigEndian.Uint64(b[2:])
+		if u>>63 != 0 {
+			return 0, wc.fail(ws.StatusProtocolError, "invalid payload length")
+		}
+		length, hl = int64(u), 10
+	}
+	if op.IsControl() && (!fin || length > 125) {
+		return 0, wc.fail(ws.StatusProtocolError, "invalid control frame")
+	}
+	// The lengths come from the client, they are compared by subtraction so that none can overflow.
+	maxMessage := wc.maxMessage
+	if maxMessage <= 0 {
+		maxMessage = wsMaxMessage
+	}
+	if length > maxMessage-int64(len(wc.fragments)) {
+		return 0, wc.fail(ws.StatusMessageTooBig, "message too big")
+	}
+	if len(b) < hl+4 || length > int64(len(b)-(hl+4)) {
+		return 0, errIncompleteRequest
+	}
+	var mask [4]byte
+	copy(mask[:], b[hl:hl+4])
+	n := hl + 4 + int(length)
+	payload := b[hl+4 : n]
+	ws.Cipher(payload, mask, 0)
+
+	switch op {
+	case ws.OpPing:
+		return n, wc.WriteMessage(ws.OpPong, payload)
+	case ws.OpPong:
+		return n, nil
+	case ws.OpClose:
+		// Echo the status code back and hang up, see RFC 6455, section 5.5.1.
+		if len(payload) >= 2 {
+			_ = wc.WriteMessage(ws.OpClose, payload[:2])
+		} else {
+			_ = wc.WriteMessage(ws.OpClose, nil)
+		}
+		return n, errWebSocketClosed
+	case ws.OpContinuation:
+		if !wc.fragmented {
+			return 0, wc.fail(ws.StatusProtocolError, "unexpected continuation frame")
+		}
+		wc.fragments = append(wc.fragments, payload...)
+		if !fin {
+			return n, nil
+		}
+		op, payload = wc.fragOp, wc.fragments
+		wc.fragmented, wc.fragments = false, wc.fragments[:0]
+	case ws.OpText, ws.OpBinary:
+		if wc.fragmented {
+			return 0, wc.fail(ws.StatusProtocolError, "expected continuation frame")
+		}
+		if !fin {
+			wc.fragmented, wc.fragOp = true, op
+			wc.fragments = append(wc.fragments[:0], payload...)
+			return n, nil
+		}
+	default:
+		return 0, wc.fail(ws.StatusProtocolError, "unknown opcode")
+	}
+	if op == ws.OpText && !utf8.Valid(payload) {
+		return 0, wc.fail(ws.StatusInvalidFramePayloadData, "invalid utf-8 text")
+	}
+	wc.handler(wc, op, payload)
+	return n, nil
+}
+
+// fail sends a close frame with code and reason, the connection is closed right after.
+func (wc *wsConn) fail(code ws.StatusCode, reason string) error {
+	_ = wc.WriteMessage(ws.OpClose, ws.NewCloseFrameBody(code, reason))
+	return errWebSocketClosed
+}
+
+// appendFrame appends an unmasked frame, as sent by servers, with a FIN bit set.
+func appendFrame(buf []byte, op ws.OpCode, payload []byte) []byte {
+	buf = append(buf, 0x80|byte(op))
+	switch n := len(payload); {
+	case n < 126:
+		buf = append(buf, byte(n))
+	case n <= 0xffff:
+		buf = append(buf, 126, byte(n>>8), byte(n))
+	default:
+		buf = append(buf, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(buf[len(buf)-8:], uint64(n))
+	}
+	return append(buf, payload...)
+}
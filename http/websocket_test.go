@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/gobwas/ws"
+)
+
+// wsTestConn records what is written to a WebSocket connection driven by a test.
+type wsTestConn struct {
+	testConn
+	out []byte
+}
+
+func (c *wsTestConn) Write(p []byte) (int, error) {
+	c.out = append(c.out, p...)
+	return len(p), nil
+}
+
+// maskedFrame builds a frame as sent by clients, with a length field announcing length bytes of payload
+// while carrying payload.
+func maskedFrame(op ws.OpCode, length uint64, payload []byte) []byte {
+	frame := []byte{0x80 | byte(op), 0x80 | 127, 0, 0, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint64(frame[2:], length)
+	frame = append(frame, 1, 2, 3, 4)
+	return append(frame, payload...)
+}
+
+func TestWebSocketFrameLength(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxMessage int64
+		length     uint64
+		close      bool
+	}{
+		{"max int64 without max body", 0, 1<<63 - 1, true},
+		{"max int64 with max body", 1 << 20, 1<<63 - 1, true},
+		{"sign bit", 0, 1 << 63, true},
+		{"beyond the hard cap", 0, wsMaxMessage + 1, true},
+		{"beyond max body", 1 << 10, 1<<10 + 1, true},
+		{"incomplete", 0, 1 << 10, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &wsTestConn{}
+			wc := &wsConn{c: c, maxMessage: tt.maxMessage, handler: func(*wsConn, ws.OpCode, []byte) {
+				t.Fatal("handler called")
+			}}
+			n, err := wc.decode(maskedFrame(ws.OpBinary, tt.length, []byte("payload")))
+			if n != 0 {
+				t.Fatalf("consumed %d bytes", n)
+			}
+			if closed := err == errWebSocketClosed; closed != tt.close {
+				t.Fatalf("got %v, want the connection closed: %t", err, tt.close)
+			}
+			if tt.close && (len(c.out) < 2 || ws.OpCode(c.out[0]&0x0f) != ws.OpClose) {
+				t.Fatalf("no close frame sent: %q", c.out)
+			}
+		})
+	}
+}
+
+func TestWebSocketMessage(t *testing.T) {
+	c := &wsTestConn{}
+	var got []byte
+	wc := &wsConn{c: c, handler: func(wc *wsConn, op ws.OpCode, payload []byte) {
+		got = append(got, payload...)
+	}}
+	payload := []byte("hello")
+	frame := maskedFrame(ws.OpText, uint64(len(payload)), nil)
+	for i, b := range payload {
+		frame = append(frame, b^byte(i%4+1))
+	}
+	if n, err := wc.decode(frame); n != len(frame) || err != nil {
+		t.Fatalf("consumed %d of %d bytes: %v", n, len(frame), err)
+	}
+	if string(got) != "hello" {
+		t.Fatalf("got %q", got)
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"time"
 
 	"github.com/panjf2000/gnet/v2"
+
+	"github.com/gnet-io/gnet-examples/config"
 )
 
 type pushServer struct {
@@ -48,18 +50,30 @@ func (ps *pushServer) OnTraffic(c gnet.Conn) gnet.Action {
 
 func main() {
 	var port int
-	var multicore bool
 	var interval time.Duration
 	var ticker bool
+	cfg := config.Server{Multicore: true}
 
 	// Example command: go run push.go --port 9000 --tick 1s --multicore=true
-	flag.IntVar(&port, "port", 9000, "server port")
-	flag.BoolVar(&multicore, "multicore", true, "multicore")
+	// or: GNET_LISTEN=tcp://[::]:9000 GNET_TCP_KEEPALIVE=1m go run push.go --tick 1s
+	cfg.Flags(flag.CommandLine)
+	flag.IntVar(&port, "port", 9000, "server port, used when no --listen is given")
 	flag.DurationVar(&interval, "tick", 100, "pushing tick")
 	flag.Parse()
+	if err := cfg.Load(flag.CommandLine); err != nil {
+		log.Fatal(err)
+	}
+	if len(cfg.Listen) == 0 {
+		cfg.Listen = []string{fmt.Sprintf("tcp://:%d", port)}
+	}
+	if err := cfg.Only("tcp"); err != nil {
+		log.Fatal(err)
+	}
 	if interval > 0 {
 		ticker = true
 	}
-	push := &pushServer{tick: interval}
-	log.Fatal(gnet.Run(push, fmt.Sprintf("tcp://:%d", port), gnet.WithMulticore(multicore), gnet.WithTicker(ticker)))
+	// Every engine ticks, each pushes to the clients of its own URL.
+	log.Fatal(cfg.Run(func(string) gnet.EventHandler {
+		return &pushServer{tick: interval}
+	}, gnet.WithTicker(ticker)))
 }
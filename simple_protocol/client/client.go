@@ -4,9 +4,9 @@ import (
 	"bufio"
 	"bytes"
 	"flag"
-	"io"
 	"math/rand"
 	"net"
+	"strings"
 	"sync"
 	"time"
 
@@ -30,23 +30,39 @@ func main() {
 		packetSize  int
 		packetBatch int
 		packetCount int
+		maxDatagram int
+		keepalive   time.Duration
+		deadTimeout time.Duration
 	)
 
-	// Example command: go run client.go --network tcp --address ":9000" --concurrency 100 --packet_size 1024 --packet_batch 20 --packet_count 1000
+	// Example command: go run . --network tcp --address ":9000" --concurrency 100 --packet_size 1024 --packet_batch 20 --packet_count 1000
+	// Keepalive: go run . --keepalive 5s --dead_timeout 15s
+	// UDP variant: go run . --network udp --address ":9000" --packet_size 256 --max_datagram 1472
 	flag.StringVar(&network, "network", "tcp", "--network tcp")
 	flag.StringVar(&addr, "address", "127.0.0.1:9000", "--address 127.0.0.1:9000")
 	flag.IntVar(&concurrency, "concurrency", 1024, "--concurrency 500")
 	flag.IntVar(&packetSize, "packet_size", 1024, "--packe_size 256")
 	flag.IntVar(&packetBatch, "packet_batch", 100, "--packe_batch 100")
 	flag.IntVar(&packetCount, "packet_count", 10000, "--packe_count 10000")
+	flag.IntVar(&maxDatagram, "max_datagram", protocol.DefaultMaxDatagramSize, "--max_datagram 1472, only for udp")
+	flag.DurationVar(&keepalive, "keepalive", 0, "--keepalive 5s, interval of pinging the server, 0 disables it")
+	flag.DurationVar(&deadTimeout, "dead_timeout", 0, "--dead_timeout 15s, give up on a server silent for this long, defaults to 3 keepalive intervals")
 	flag.Parse()
+	if keepalive > 0 && deadTimeout <= 0 {
+		deadTimeout = 3 * keepalive
+	}
+	datagram := strings.HasPrefix(network, "udp")
 
 	logging.Infof("start %d clients...", concurrency)
 	var wg sync.WaitGroup
 	wg.Add(concurrency)
 	for i := 0; i < concurrency; i++ {
 		go func() {
-			runClient(network, addr, packetSize, packetBatch, packetCount)
+			if datagram {
+				runUDPClient(network, addr, packetSize, packetBatch, packetCount, maxDatagram)
+			} else {
+				runClient(network, addr, packetSize, packetBatch, packetCount, keepalive, deadTimeout)
+			}
 			wg.Done()
 		}()
 	}
@@ -54,7 +70,7 @@ func main() {
 	logging.Infof("all %d clients are done", concurrency)
 }
 
-func runClient(network, addr string, packetSize, batch, count int) {
+func runClient(network, addr string, packetSize, batch, count int, keepalive, deadTimeout time.Duration) {
 	rand.Seed(time.Now().UnixNano())
 	c, err := net.Dial(network, addr)
 	logErr(err)
@@ -71,17 +87,26 @@ func runClient(network, addr string, packetSize, batch, count int) {
 		logging.Fatalf("the first response packet mismatches, expect: %s, but got: %s", expectMsg, msg)
 	}
 
+	if keepalive > 0 {
+		stop := startKeepalive(c, keepalive)
+		defer stop()
+	}
 	for i := 0; i < count; i++ {
-		batchSendAndRecv(c, rd, packetSize, batch)
+		if err = batchSendAndRecv(c, rd, packetSize, batch, deadTimeout); err != nil {
+			logging.Errorf("connection=%s: server has been silent for %v, treating it as dead: %v",
+				c.LocalAddr().String(), deadTimeout, err)
+			return
+		}
 	}
 }
 
-func batchSendAndRecv(c net.Conn, rd *bufio.Reader, packetSize, batch int) {
+// batchSendAndRecv reads the responses packet by packet so that pings from the server can be answered in between,
+// it returns an error only when the server stays silent for deadTimeout.
+func batchSendAndRecv(c net.Conn, rd *bufio.Reader, packetSize, batch int, deadTimeout time.Duration) error {
 	codec := protocol.SimpleCodec{}
 	var (
-		requests  [][]byte
-		buf       []byte
-		packetLen int
+		requests [][]byte
+		buf      []byte
 	)
 	for i := 0; i < batch; i++ {
 		req := make([]byte, packetSize)
@@ -89,20 +114,23 @@ func batchSendAndRecv(c net.Conn, rd *bufio.Reader, packetSize, batch int) {
 		logErr(err)
 		requests = append(requests, req)
 		packet, _ := codec.Encode(req)
-		packetLen = len(packet)
 		buf = append(buf, packet...)
 	}
 	_, err := c.Write(buf)
 	logErr(err)
-	respPacket := make([]byte, batch*packetLen)
-	_, err = io.ReadFull(rd, respPacket)
-	logErr(err)
-	for i, req := range requests {
-		rsp, err := codec.Unpack(respPacket[i*packetLen:])
+	for _, req := range requests {
+		if deadTimeout > 0 {
+			logErr(c.SetReadDeadline(time.Now().Add(deadTimeout)))
+		}
+		rsp, err := codec.ReadPacket(rd, c)
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return err
+		}
 		logErr(err)
 		if !bytes.Equal(req, rsp) {
 			logging.Fatalf("request and response mismatch, conn=%s, packet size: %d, batch: %d",
 				c.LocalAddr().String(), packetSize, batch)
 		}
 	}
+	return nil
 }
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net"
+	"time"
+
+	"github.com/panjf2000/gnet/v2/pkg/logging"
+
+	"github.com/gnet-io/gnet-examples/simple_protocol/protocol"
+)
+
+// startKeepalive pings the server every interval until the returned stop function is called,
+// the pongs are consumed by ReadPacket and keep the read deadline from expiring on an otherwise quiet connection.
+func startKeepalive(c net.Conn, interval time.Duration) (stop func()) {
+	ping := protocol.SimpleCodec{}.EncodePing(nil)
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if _, err := c.Write(ping); err != nil {
+					logging.Warnf("connection=%s failed to send ping: %v", c.LocalAddr().String(), err)
+					return
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
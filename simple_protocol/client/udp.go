@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/panjf2000/gnet/v2/pkg/logging"
+
+	"github.com/gnet-io/gnet-examples/simple_protocol/protocol"
+)
+
+const udpReadTimeout = 3 * time.Second
+
+func runUDPClient(network, addr string, packetSize, batch, count, maxDatagram int) {
+	rand.Seed(time.Now().UnixNano())
+	c, err := net.Dial(network, addr)
+	logErr(err)
+	logging.Infof("connection=%s starts...", c.LocalAddr().String())
+	defer func() {
+		logging.Infof("connection=%s stops...", c.LocalAddr().String())
+		c.Close()
+	}()
+
+	// The server sends nothing until it receives a datagram, so there is no greeting to wait for.
+	buf := make([]byte, 64*1024)
+	for i := 0; i < count; i++ {
+		batchSendAndRecvDatagrams(c, buf, packetSize, batch, maxDatagram)
+	}
+}
+
+// batchSendAndRecvDatagrams packs a batch of requests into datagrams and expects every packet to be echoed back,
+// replies are matched in order, which holds on the loopback interface where datagrams are neither lost nor reordered.
+func batchSendAndRecvDatagrams(c net.Conn, buf []byte, packetSize, batch, maxDatagram int) {
+	codec := protocol.SimpleCodec{}
+	requests := make([][]byte, 0, batch)
+	for i := 0; i < batch; i++ {
+		req := make([]byte, packetSize)
+		_, err := rand.Read(req)
+		logErr(err)
+		requests = append(requests, req)
+	}
+	datagrams, err := codec.PackDatagrams(requests, maxDatagram)
+	logErr(err)
+	for _, datagram := range datagrams {
+		_, err = c.Write(datagram)
+		logErr(err)
+	}
+
+	for received := 0; received < len(requests); {
+		logErr(c.SetReadDeadline(time.Now().Add(udpReadTimeout)))
+		n, err := c.Read(buf)
+		logErr(err)
+		responses, err := codec.UnpackDatagram(buf[:n], maxDatagram)
+		logErr(err)
+		for _, rsp := range responses {
+			if received >= len(requests) || !bytes.Equal(requests[received], rsp) {
+				logging.Fatalf("request and response mismatch, conn=%s, packet size: %d, batch: %d",
+					c.LocalAddr().String(), packetSize, batch)
+			}
+			received++
+		}
+	}
+}
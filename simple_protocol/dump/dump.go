@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gnet-io/gnet-examples/simple_protocol/protocol"
+)
+
+// stream is a contiguous run of bytes to be decoded frame-by-frame. A run of a captured TCP flow starts offset
+// bytes into the flow, after gap bytes missing from the capture.
+type stream struct {
+	name   string
+	data   []byte
+	offset int
+	gap    int
+}
+
+func main() {
+	var (
+		input   string
+		format  string
+		preview int
+		port    int
+		resync  bool
+	)
+
+	// Example command: go run dump.go pcap.go --input capture.pcap --format pcap --port 9000
+	flag.StringVar(&input, "input", "-", "--input stream.bin, \"-\" reads from stdin")
+	flag.StringVar(&format, "format", "raw", "--format raw|hex|pcap")
+	flag.IntVar(&preview, "preview", 32, "--preview 32, number of body bytes to print")
+	flag.IntVar(&port, "port", 0, "--port 9000, only decode TCP segments to or from this port (pcap only)")
+	flag.BoolVar(&resync, "resync", false, "--resync=true, scan forward for the next magic number after an error")
+	flag.Parse()
+
+	data, err := readInput(input)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read input: %v\n", err)
+		os.Exit(2)
+	}
+
+	var streams []stream
+	switch format {
+	case "raw":
+		streams = []stream{{name: input, data: data}}
+	case "hex":
+		var raw []byte
+		if raw, err = decodeHexDump(data); err == nil {
+			streams = []stream{{name: input, data: raw}}
+		}
+	case "pcap":
+		streams, err = extractTCPStreams(data, port)
+	default:
+		err = fmt.Errorf("unknown format %q", format)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to decode input: %v\n", err)
+		os.Exit(2)
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	var failed bool
+	for _, s := range streams {
+		if !dumpStream(w, s, preview, resync) {
+			failed = true
+		}
+	}
+	if failed {
+		w.Flush()
+		os.Exit(1)
+	}
+}
+
+func readInput(input string) ([]byte, error) {
+	if input == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(input)
+}
+
+// decodeHexDump accepts plain hex strings as well as the output of `xxd` and `hexdump -C`,
+// ignoring offset columns and the trailing ASCII column.
+func decodeHexDump(data []byte) ([]byte, error) {
+	var out []byte
+	for i, line := range strings.Split(string(data), "\n") {
+		if idx := strings.IndexByte(line, '|'); idx >= 0 { // hexdump -C
+			line = line[:idx]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if first := fields[0]; strings.HasSuffix(first, ":") { // xxd
+			line = strings.TrimSpace(line[strings.IndexByte(line, ':')+1:])
+			if idx := strings.Index(line, "  "); idx >= 0 {
+				line = line[:idx]
+			}
+			fields = strings.Fields(line)
+		} else if len(fields) > 1 && len(first) >= 7 && isHex(first) && len(fields[1]) == 2 { // hexdump -C
+			fields = fields[1:]
+		}
+		for _, f := range fields {
+			f = strings.TrimPrefix(strings.TrimPrefix(f, "0x"), "0X")
+			b, err := hex.DecodeString(f)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", i+1, err)
+			}
+			out = append(out, b...)
+		}
+	}
+	return out, nil
+}
+
+func isHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// dumpStream prints every frame found in s and reports whether the whole stream was decoded cleanly.
+func dumpStream(w io.Writer, s stream, preview int, resync bool) (ok bool) {
+	codec := protocol.SimpleCodec{}
+	fmt.Fprintf(w, "== %s (%d bytes)\n", s.name, len(s.data))
+	ok = true
+	var frames int
+	// Offsets are printed from the start of the flow, data starts s.offset bytes into it.
+	offset := s.offset
+	if s.gap > 0 {
+		// The run may start in the middle of a frame.
+		ok = false
+		fmt.Fprintf(w, "offset=%d: %d bytes missing from the capture\n", offset-s.gap, s.gap)
+		next := nextMagic(s.data)
+		if next < 0 {
+			fmt.Fprintf(w, "offset=%d: no magic number found, skipped %d bytes\n", offset, len(s.data))
+			return
+		}
+		if next > 0 {
+			fmt.Fprintf(w, "offset=%d: resynchronized after skipping %d bytes\n", offset+next, next)
+		}
+		offset += next
+	}
+	for end := s.offset + len(s.data); offset < end; {
+		buf := s.data[offset-s.offset:]
+		h, err := codec.ParseHeader(buf)
+		switch err {
+		case nil:
+		case protocol.ErrIncompletePacket:
+			fmt.Fprintf(w, "offset=%d: truncated header, need %d bytes but only %d remain\n",
+				offset, protocol.HeaderSize, len(buf))
+			return false
+		case protocol.ErrInvalidMagicNumber:
+			fmt.Fprintf(w, "offset=%d: invalid magic number 0x%04x\n", offset, h.Magic)
+			ok = false
+			if !resync {
+				return
+			}
+			next := nextMagic(buf[1:])
+			if next < 0 {
+				fmt.Fprintf(w, "offset=%d: no further magic number found, skipped %d bytes\n", offset, len(buf))
+				return
+			}
+			fmt.Fprintf(w, "offset=%d: resynchronized after skipping %d bytes\n", offset+1+next, 1+next)
+			offset += 1 + next
+			continue
+		}
+
+		frames++
+		msgLen := protocol.HeaderSize + int(h.BodyLen)
+		fmt.Fprintf(w, "frame #%d offset=%d magic=%d%s body_len=%d\n", frames, offset, h.Magic, frameKind(h), h.BodyLen)
+		if len(buf) < msgLen {
+			fmt.Fprintf(w, "offset=%d: truncated body, need %d bytes but only %d remain\n",
+				offset+protocol.HeaderSize, h.BodyLen, len(buf)-protocol.HeaderSize)
+			printPreview(w, buf[protocol.HeaderSize:], preview)
+			return false
+		}
+		printPreview(w, buf[protocol.HeaderSize:msgLen], preview)
+		offset += msgLen
+	}
+	fmt.Fprintf(w, "%d frame(s) decoded\n", frames)
+	return
+}
+
+func frameKind(h protocol.Header) string {
+	switch {
+	case h.IsPing():
+		return " (ping)"
+	case h.IsPong():
+		return " (pong)"
+	}
+	return ""
+}
+
+func nextMagic(buf []byte) int {
+	var magic [2]byte
+	binary.BigEndian.PutUint16(magic[:], protocol.MagicNumber)
+	return bytes.Index(buf, magic[:])
+}
+
+func printPreview(w io.Writer, body []byte, preview int) {
+	if preview <= 0 || len(body) == 0 {
+		return
+	}
+	suffix := ""
+	if len(body) > preview {
+		body = body[:preview]
+		suffix = "..."
+	}
+	fmt.Fprintf(w, "  hex:  % x%s\n", body, suffix)
+	// Drop a rune that may have been cut in half by the preview limit.
+	for i := 0; i < utf8.UTFMax && len(body) > 0 && !utf8.Valid(body); i++ {
+		body = body[:len(body)-1]
+	}
+	if utf8.Valid(body) {
+		fmt.Fprintf(w, "  utf8: %q%s\n", body, suffix)
+	} else {
+		fmt.Fprintf(w, "  utf8: (not valid UTF-8)\n")
+	}
+}
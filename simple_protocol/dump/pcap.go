@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+)
+
+const (
+	pcapMagicMicros      = 0xa1b2c3d4
+	pcapMagicNanos       = 0xa1b23c4d
+	pcapGlobalHeaderSize = 24
+	pcapRecordHeaderSize = 16
+
+	linkTypeNull     = 0
+	linkTypeEthernet = 1
+	linkTypeRaw      = 101
+	linkTypeLinuxSLL = 113
+
+	etherTypeIPv4 = 0x0800
+	etherTypeIPv6 = 0x86dd
+	etherTypeVLAN = 0x8100
+
+	ipProtoTCP = 6
+)
+
+var errNotTCP = errors.New("not a TCP segment")
+
+// flow reassembles the payload of one direction of a TCP connection.
+type flow struct {
+	key     string
+	order   int
+	isn     uint32 // sequence number of the first payload byte seen
+	nextSeq uint32
+	started bool
+	// runs are the contiguous parts of the payload, split where bytes are missing from the capture,
+	// early holds the segments received ahead of nextSeq by sequence number.
+	runs  []run
+	early map[uint32][]byte
+}
+
+// run is a contiguous part of a flow starting offset bytes into it, gap bytes before it are missing.
+type run struct {
+	offset int
+	gap    int
+	data   []byte
+}
+
+// extractTCPStreams reads a classic libpcap file and reassembles the TCP payload of every
+// connection direction, optionally restricted to segments to or from port.
+func extractTCPStreams(data []byte, port int) ([]stream, error) {
+	if len(data) < pcapGlobalHeaderSize {
+		return nil, errors.New("pcap: file too short")
+	}
+	var order binary.ByteOrder
+	switch magic := binary.LittleEndian.Uint32(data); magic {
+	case pcapMagicMicros, pcapMagicNanos:
+		order = binary.LittleEndian
+	default:
+		switch binary.BigEndian.Uint32(data) {
+		case pcapMagicMicros, pcapMagicNanos:
+			order = binary.BigEndian
+		default:
+			return nil, fmt.Errorf("pcap: unknown magic 0x%08x, pcapng is not supported", magic)
+		}
+	}
+	linkType := order.Uint32(data[20:24])
+
+	flows := make(map[string]*flow)
+	for off := pcapGlobalHeaderSize; off < len(data); {
+		if len(data)-off < pcapRecordHeaderSize {
+			return nil, fmt.Errorf("pcap: truncated record header at offset %d", off)
+		}
+		inclLen := int(order.Uint32(data[off+8 : off+12]))
+		off += pcapRecordHeaderSize
+		if len(data)-off < inclLen {
+			return nil, fmt.Errorf("pcap: truncated record at offset %d", off)
+		}
+		frame := data[off : off+inclLen]
+		off += inclLen
+
+		key, srcPort, dstPort, seq, payload, err := parseFrame(linkType, frame)
+		if err != nil || len(payload) == 0 {
+			continue
+		}
+		if port > 0 && srcPort != port && dstPort != port {
+			continue
+		}
+		f, ok := flows[key]
+		if !ok {
+			f = &flow{key: key, order: len(flows)}
+			flows[key] = f
+		}
+		f.append(seq, payload)
+	}
+
+	all := make([]*flow, 0, len(flows))
+	for _, f := range flows {
+		all = append(all, f)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].order < all[j].order })
+	streams := make([]stream, 0, len(all))
+	for _, f := range all {
+		f.finish()
+		for _, r := range f.runs {
+			streams = append(streams, stream{name: f.key, data: r.data, offset: r.offset, gap: r.gap})
+		}
+	}
+	return streams, nil
+}
+
+// append adds a segment to the flow: retransmitted bytes are dropped, and a segment ahead of the bytes
+// received so far waits for those in between.
+func (f *flow) append(seq uint32, payload []byte) {
+	if !f.started {
+		f.started, f.isn, f.nextSeq = true, seq, seq
+		f.runs = []run{{}}
+	}
+	switch diff := int32(seq - f.nextSeq); {
+	case diff > 0:
+		if f.early == nil {
+			f.early = make(map[uint32][]byte)
+		}
+		if len(payload) > len(f.early[seq]) {
+			f.early[seq] = payload
+		}
+		return
+	case diff < 0:
+		if int(-diff) >= len(payload) {
+			return
+		}
+		payload = payload[-diff:]
+	}
+	f.extend(payload)
+	f.drain()
+}
+
+func (f *flow) extend(payload []byte) {
+	r := &f.runs[len(f.runs)-1]
+	r.data = append(r.data, payload...)
+	f.nextSeq += uint32(len(payload))
+}
+
+// drain appends the early segments the flow has caught up with.
+func (f *flow) drain() {
+	for more := true; more; {
+		more = false
+		for seq, payload := range f.early {
+			diff := int32(f.nextSeq - seq)
+			if diff < 0 {
+				continue
+			}
+			delete(f.early, seq)
+			if int(diff) < len(payload) {
+				f.extend(payload[diff:])
+				more = true
+			}
+		}
+	}
+}
+
+// finish skips the bytes missing from the capture, the early segments left start a new run after each gap.
+func (f *flow) finish() {
+	for len(f.early) > 0 {
+		var next uint32
+		first := true
+		for seq := range f.early {
+			if first || int32(seq-next) < 0 {
+				next, first = seq, false
+			}
+		}
+		f.runs = append(f.runs, run{offset: int(next - f.isn), gap: int(next - f.nextSeq)})
+		f.nextSeq = next
+		f.drain()
+	}
+}
+
+func parseFrame(linkType uint32, frame []byte) (key string, srcPort, dstPort int, seq uint32, payload []byte, err error) {
+	var etherType uint16
+	switch linkType {
+	case linkTypeEthernet:
+		if len(frame) < 14 {
+			return "", 0, 0, 0, nil, errNotTCP
+		}
+		etherType = binary.BigEndian.Uint16(frame[12:14])
+		frame = frame[14:]
+		for etherType == etherTypeVLAN && len(frame) >= 4 {
+			etherType = binary.BigEndian.Uint16(frame[2:4])
+			frame = frame[4:]
+		}
+	case linkTypeLinuxSLL:
+		if len(frame) < 16 {
+			return "", 0, 0, 0, nil, errNotTCP
+		}
+		etherType = binary.BigEndian.Uint16(frame[14:16])
+		frame = frame[16:]
+	case linkTypeNull:
+		if len(frame) < 4 {
+			return "", 0, 0, 0, nil, errNotTCP
+		}
+		frame = frame[4:]
+		etherType = ipVersionToEtherType(frame)
+	case linkTypeRaw:
+		etherType = ipVersionToEtherType(frame)
+	default:
+		return "", 0, 0, 0, nil, fmt.Errorf("pcap: unsupported link type %d", linkType)
+	}
+
+	var src, dst net.IP
+	var segment []byte
+	switch etherType {
+	case etherTypeIPv4:
+		if len(frame) < 20 || frame[9] != ipProtoTCP {
+			return "", 0, 0, 0, nil, errNotTCP
+		}
+		ihl := int(frame[0]&0x0f) * 4
+		total := int(binary.BigEndian.Uint16(frame[2:4]))
+		if ihl < 20 || total < ihl || len(frame) < ihl {
+			return "", 0, 0, 0, nil, errNotTCP
+		}
+		if total < len(frame) { // strip Ethernet padding
+			frame = frame[:total]
+		}
+		src, dst, segment = net.IP(frame[12:16]), net.IP(frame[16:20]), frame[ihl:]
+	case etherTypeIPv6:
+		// Extension headers are not followed, which is fine for plain TCP captures.
+		if len(frame) < 40 || frame[6] != ipProtoTCP {
+			return "", 0, 0, 0, nil, errNotTCP
+		}
+		plen := int(binary.BigEndian.Uint16(frame[4:6]))
+		if 40+plen < len(frame) {
+			frame = frame[:40+plen]
+		}
+		src, dst, segment = net.IP(frame[8:24]), net.IP(frame[24:40]), frame[40:]
+	default:
+		return "", 0, 0, 0, nil, errNotTCP
+	}
+
+	if len(segment) < 20 {
+		return "", 0, 0, 0, nil, errNotTCP
+	}
+	srcPort = int(binary.BigEndian.Uint16(segment[0:2]))
+	dstPort = int(binary.BigEndian.Uint16(segment[2:4]))
+	seq = binary.BigEndian.Uint32(segment[4:8])
+	dataOffset := int(segment[12]>>4) * 4
+	if dataOffset < 20 || len(segment) < dataOffset {
+		return "", 0, 0, 0, nil, errNotTCP
+	}
+	key = fmt.Sprintf("%s -> %s",
+		net.JoinHostPort(src.String(), fmt.Sprint(srcPort)), net.JoinHostPort(dst.String(), fmt.Sprint(dstPort)))
+	return key, srcPort, dstPort, seq, segment[dataOffset:], nil
+}
+
+func ipVersionToEtherType(packet []byte) uint16 {
+	if len(packet) == 0 {
+		return 0
+	}
+	switch packet[0] >> 4 {
+	case 4:
+		return etherTypeIPv4
+	case 6:
+		return etherTypeIPv6
+	}
+	return 0
+}
@@ -0,0 +1,67 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+)
+
+// DefaultMaxDatagramSize keeps a datagram within a single Ethernet frame:
+// 1500 bytes of MTU minus 20 bytes of IPv4 header and 8 bytes of UDP header.
+const DefaultMaxDatagramSize = 1472
+
+var (
+	ErrFrameSpansDatagram = errors.New("frame spans datagram boundary")
+	ErrDatagramTooLarge   = errors.New("datagram exceeds max size")
+)
+
+// UnpackDatagram splits one datagram into the bodies of the data packets it carries, control frames are skipped:
+// keepalive is for stream connections, a datagram has no connection to keep alive. Unlike a byte stream, a datagram must hold whole packets only: a trailing partial header or body
+// is reported as ErrFrameSpansDatagram rather than ErrIncompletePacket, since the rest of it will never arrive.
+// The returned bodies reference buf. maxSize <= 0 means DefaultMaxDatagramSize, as for PackDatagrams,
+// so that whatever is unpacked can be packed again.
+func (codec SimpleCodec) UnpackDatagram(buf []byte, maxSize int) (bodies [][]byte, err error) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxDatagramSize
+	}
+	if len(buf) > maxSize {
+		return nil, fmt.Errorf("%w: %d > %d", ErrDatagramTooLarge, len(buf), maxSize)
+	}
+	for offset := 0; offset < len(buf); {
+		h, body, err := codec.Unpack(buf[offset:])
+		if err == ErrIncompletePacket {
+			return nil, fmt.Errorf("%w at offset %d", ErrFrameSpansDatagram, offset)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w at offset %d", err, offset)
+		}
+		if !h.IsPing() && !h.IsPong() {
+			bodies = append(bodies, body)
+		}
+		offset += HeaderSize + len(body)
+	}
+	return
+}
+
+// PackDatagrams encodes bodies into as few datagrams as possible, each no larger than maxSize,
+// a packet is never split across two datagrams. maxSize <= 0 means DefaultMaxDatagramSize.
+func (codec SimpleCodec) PackDatagrams(bodies [][]byte, maxSize int) (datagrams [][]byte, err error) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxDatagramSize
+	}
+	var cur []byte
+	for _, body := range bodies {
+		packet, _ := codec.Encode(body)
+		if len(packet) > maxSize {
+			return nil, fmt.Errorf("%w: packet of %d bytes > %d", ErrDatagramTooLarge, len(packet), maxSize)
+		}
+		if len(cur)+len(packet) > maxSize {
+			datagrams = append(datagrams, cur)
+			cur = nil
+		}
+		cur = append(cur, packet...)
+	}
+	if len(cur) > 0 {
+		datagrams = append(datagrams, cur)
+	}
+	return
+}
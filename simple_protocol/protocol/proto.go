@@ -1,27 +1,30 @@
 package protocol
 
 import (
-	"bytes"
 	"encoding/binary"
 	"errors"
+	"io"
 
 	"github.com/panjf2000/gnet/v2"
 )
 
-var ErrIncompletePacket = errors.New("incomplete packet")
+var (
+	ErrIncompletePacket   = errors.New("incomplete packet")
+	ErrInvalidMagicNumber = errors.New("invalid magic number")
+)
 
 const (
 	magicNumber     = 1314
+	pingMagicNumber = 1315
+	pongMagicNumber = 1316
 	magicNumberSize = 2
 	bodySize        = 4
-)
 
-var magicNumberBytes []byte
-
-func init() {
-	magicNumberBytes = make([]byte, magicNumberSize)
-	binary.BigEndian.PutUint16(magicNumberBytes, uint16(magicNumber))
-}
+	// MagicNumber leads every data packet on the wire.
+	MagicNumber = magicNumber
+	// HeaderSize is the length of the fixed header preceding every body.
+	HeaderSize = magicNumberSize + bodySize
+)
 
 // SimpleCodec Protocol format:
 //
@@ -35,57 +38,141 @@ func init() {
 // * +                                   +
 // * |            ... ...                |
 // * +-----------------------------------+
+//
+// Packets led by the ping and pong magic numbers are control frames used as keepalive,
+// a ping is answered with a pong carrying the same body.
 type SimpleCodec struct{}
 
 func (codec SimpleCodec) Encode(buf []byte) ([]byte, error) {
+	return codec.encode(magicNumber, buf), nil
+}
+
+// EncodePing returns a ping control frame, payload is echoed back in the pong.
+func (codec SimpleCodec) EncodePing(payload []byte) []byte {
+	return codec.encode(pingMagicNumber, payload)
+}
+
+// EncodePong returns a pong control frame answering a ping that carried payload.
+func (codec SimpleCodec) EncodePong(payload []byte) []byte {
+	return codec.encode(pongMagicNumber, payload)
+}
+
+func (codec SimpleCodec) encode(magic uint16, buf []byte) []byte {
 	bodyOffset := magicNumberSize + bodySize
 	msgLen := bodyOffset + len(buf)
 
 	data := make([]byte, msgLen)
-	copy(data, magicNumberBytes)
+	binary.BigEndian.PutUint16(data, magic)
 
 	binary.BigEndian.PutUint32(data[magicNumberSize:bodyOffset], uint32(len(buf)))
 	copy(data[bodyOffset:msgLen], buf)
-	return data, nil
+	return data
 }
 
+// Decode returns the body of the next data packet, control frames ahead of it are consumed:
+// pings are answered with pongs right away and pongs are dropped, neither reaches the caller.
 func (codec *SimpleCodec) Decode(c gnet.Conn) ([]byte, error) {
 	bodyOffset := magicNumberSize + bodySize
-	buf, _ := c.Peek(bodyOffset)
-	if len(buf) < bodyOffset {
-		return nil, ErrIncompletePacket
+	for {
+		buf, _ := c.Peek(bodyOffset)
+		if len(buf) < bodyOffset {
+			return nil, ErrIncompletePacket
+		}
+
+		h, err := codec.ParseHeader(buf)
+		if err != nil {
+			return nil, err
+		}
+
+		msgLen := bodyOffset + int(h.BodyLen)
+		if c.InboundBuffered() < msgLen {
+			return nil, ErrIncompletePacket
+		}
+		buf, _ = c.Peek(msgLen)
+		switch h.Magic {
+		case pingMagicNumber:
+			pong := codec.EncodePong(buf[bodyOffset:msgLen])
+			_, _ = c.Discard(msgLen)
+			_, _ = c.Write(pong)
+			continue
+		case pongMagicNumber:
+			_, _ = c.Discard(msgLen)
+			continue
+		}
+		_, _ = c.Discard(msgLen)
+
+		return buf[bodyOffset:msgLen], nil
 	}
+}
 
-	if !bytes.Equal(magicNumberBytes, buf[:magicNumberSize]) {
-		return nil, errors.New("invalid magic number")
+// Unpack decodes the packet at the start of buf, a data packet or a control frame as h tells,
+// the body references buf.
+func (codec SimpleCodec) Unpack(buf []byte) (h Header, body []byte, err error) {
+	if h, err = codec.ParseHeader(buf); err != nil {
+		return
 	}
+	msgLen := HeaderSize + int(h.BodyLen)
+	if len(buf) < msgLen {
+		return h, nil, ErrIncompletePacket
+	}
+	return h, buf[HeaderSize:msgLen], nil
+}
 
-	bodyLen := binary.BigEndian.Uint32(buf[magicNumberSize:bodyOffset])
-	msgLen := bodyOffset + int(bodyLen)
-	if c.InboundBuffered() < msgLen {
-		return nil, ErrIncompletePacket
+// ReadPacket is the blocking counterpart of Decode for clients built on net.Conn:
+// it reads packets from r until a data packet arrives, answering pings to w and dropping pongs.
+func (codec SimpleCodec) ReadPacket(r io.Reader, w io.Writer) ([]byte, error) {
+	header := make([]byte, HeaderSize)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return nil, err
+		}
+		h, err := codec.ParseHeader(header)
+		if err != nil {
+			return nil, err
+		}
+		body := make([]byte, h.BodyLen)
+		if _, err = io.ReadFull(r, body); err != nil {
+			return nil, err
+		}
+		switch {
+		case h.IsPing():
+			if _, err = w.Write(codec.EncodePong(body)); err != nil {
+				return nil, err
+			}
+		case h.IsPong():
+		default:
+			return body, nil
+		}
 	}
-	buf, _ = c.Peek(msgLen)
-	_, _ = c.Discard(msgLen)
+}
 
-	return buf[bodyOffset:msgLen], nil
+// Header holds the fixed-size fields that lead every packet.
+type Header struct {
+	Magic   uint16
+	BodyLen uint32
 }
 
-func (codec SimpleCodec) Unpack(buf []byte) ([]byte, error) {
-	bodyOffset := magicNumberSize + bodySize
-	if len(buf) < bodyOffset {
-		return nil, ErrIncompletePacket
-	}
+// IsPing reports whether the header leads a ping control frame.
+func (h Header) IsPing() bool {
+	return h.Magic == pingMagicNumber
+}
 
-	if !bytes.Equal(magicNumberBytes, buf[:magicNumberSize]) {
-		return nil, errors.New("invalid magic number")
-	}
+// IsPong reports whether the header leads a pong control frame.
+func (h Header) IsPong() bool {
+	return h.Magic == pongMagicNumber
+}
 
-	bodyLen := binary.BigEndian.Uint32(buf[magicNumberSize:bodyOffset])
-	msgLen := bodyOffset + int(bodyLen)
-	if len(buf) < msgLen {
-		return nil, ErrIncompletePacket
+// ParseHeader decodes the header at the start of buf without requiring the body to be present,
+// it returns ErrIncompletePacket if buf is shorter than HeaderSize.
+func (codec SimpleCodec) ParseHeader(buf []byte) (h Header, err error) {
+	if len(buf) < HeaderSize {
+		return h, ErrIncompletePacket
 	}
-
-	return buf[bodyOffset:msgLen], nil
+	h.Magic = binary.BigEndian.Uint16(buf[:magicNumberSize])
+	h.BodyLen = binary.BigEndian.Uint32(buf[magicNumberSize:HeaderSize])
+	switch h.Magic {
+	case magicNumber, pingMagicNumber, pongMagicNumber:
+		return h, nil
+	}
+	return h, ErrInvalidMagicNumber
 }
@@ -0,0 +1,78 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Op is the operation carried by a publish/subscribe command.
+type Op byte
+
+const (
+	OpSubscribe Op = iota + 1
+	OpUnsubscribe
+	OpPublish
+	// OpMessage is sent by the server to deliver a published payload to a subscriber.
+	OpMessage
+)
+
+// MaxTopicLen is the longest topic name a command can carry.
+const MaxTopicLen = 255
+
+var ErrInvalidCommand = errors.New("invalid command")
+
+func (op Op) String() string {
+	switch op {
+	case OpSubscribe:
+		return "SUBSCRIBE"
+	case OpUnsubscribe:
+		return "UNSUBSCRIBE"
+	case OpPublish:
+		return "PUBLISH"
+	case OpMessage:
+		return "MESSAGE"
+	}
+	return fmt.Sprintf("Op(%d)", byte(op))
+}
+
+// Command is the body of a packet exchanged in publish/subscribe mode.
+//
+// * 0      1           2
+// * +------+-----------+-----------------+-----------------+
+// * |  op  | topic len |   topic bytes   |  payload bytes  |
+// * +------+-----------+-----------------+-----------------+
+type Command struct {
+	Op      Op
+	Topic   string
+	Payload []byte
+}
+
+// EncodeCommand returns the whole packet, header included, carrying cmd.
+func (codec SimpleCodec) EncodeCommand(cmd Command) ([]byte, error) {
+	if len(cmd.Topic) == 0 || len(cmd.Topic) > MaxTopicLen {
+		return nil, fmt.Errorf("%w: topic length %d out of range", ErrInvalidCommand, len(cmd.Topic))
+	}
+	body := make([]byte, 0, 2+len(cmd.Topic)+len(cmd.Payload))
+	body = append(body, byte(cmd.Op), byte(len(cmd.Topic)))
+	body = append(body, cmd.Topic...)
+	body = append(body, cmd.Payload...)
+	return codec.Encode(body)
+}
+
+// DecodeCommand parses a packet body, the returned Payload references body.
+func (codec SimpleCodec) DecodeCommand(body []byte) (cmd Command, err error) {
+	if len(body) < 2 {
+		return cmd, fmt.Errorf("%w: body of %d bytes is too short", ErrInvalidCommand, len(body))
+	}
+	cmd.Op = Op(body[0])
+	if cmd.Op < OpSubscribe || cmd.Op > OpMessage {
+		return cmd, fmt.Errorf("%w: unknown op %d", ErrInvalidCommand, body[0])
+	}
+	topicLen := int(body[1])
+	if topicLen == 0 || len(body) < 2+topicLen {
+		return cmd, fmt.Errorf("%w: bad topic length %d", ErrInvalidCommand, topicLen)
+	}
+	cmd.Topic = string(body[2 : 2+topicLen])
+	cmd.Payload = body[2+topicLen:]
+	return cmd, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/panjf2000/gnet/v2/pkg/logging"
+
+	"github.com/gnet-io/gnet-examples/simple_protocol/protocol"
+)
+
+func logErr(err error) {
+	logging.Error(err)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func main() {
+	var (
+		network  string
+		addr     string
+		mode     string
+		topics   string
+		message  string
+		count    int
+		interval time.Duration
+	)
+
+	// Example commands, against a server started with --pubsub=true:
+	// go run pubsub.go --mode sub --topics news,sports
+	// go run pubsub.go --mode pub --topics news --message "hello" --count 10 --interval 1s
+	flag.StringVar(&network, "network", "tcp", "--network tcp")
+	flag.StringVar(&addr, "address", "127.0.0.1:9000", "--address 127.0.0.1:9000")
+	flag.StringVar(&mode, "mode", "sub", "--mode sub|pub")
+	flag.StringVar(&topics, "topics", "default", "--topics news,sports")
+	flag.StringVar(&message, "message", "hello", "--message hello, payload to publish")
+	flag.IntVar(&count, "count", 1, "--count 10, number of messages to publish to every topic")
+	flag.DurationVar(&interval, "interval", 0, "--interval 1s, pause between published messages")
+	flag.Parse()
+
+	c, err := net.Dial(network, addr)
+	logErr(err)
+	defer c.Close()
+	rd := bufio.NewReader(c)
+	msg, err := rd.ReadBytes('\n')
+	logErr(err)
+	if expectMsg := "sweetness\r\n"; string(msg) != expectMsg {
+		logging.Fatalf("the first response packet mismatches, expect: %s, but got: %s", expectMsg, msg)
+	}
+
+	switch mode {
+	case "sub":
+		subscribe(c, rd, strings.Split(topics, ","))
+	case "pub":
+		publish(c, strings.Split(topics, ","), message, count, interval)
+	default:
+		logging.Fatalf("unknown mode %q", mode)
+	}
+}
+
+func subscribe(c net.Conn, rd *bufio.Reader, topics []string) {
+	codec := protocol.SimpleCodec{}
+	for _, topic := range topics {
+		packet, err := codec.EncodeCommand(protocol.Command{Op: protocol.OpSubscribe, Topic: topic})
+		logErr(err)
+		_, err = c.Write(packet)
+		logErr(err)
+	}
+	logging.Infof("subscribed to %v", topics)
+
+	for {
+		// Pings from a server running with --ping_interval are answered inside ReadPacket.
+		body, err := codec.ReadPacket(rd, c)
+		if err == io.EOF {
+			logging.Infof("server closed the connection")
+			return
+		}
+		logErr(err)
+		cmd, err := codec.DecodeCommand(body)
+		logErr(err)
+		logging.Infof("[%s] %s: %s", cmd.Op, cmd.Topic, cmd.Payload)
+	}
+}
+
+func publish(c net.Conn, topics []string, message string, count int, interval time.Duration) {
+	codec := protocol.SimpleCodec{}
+	for i := 0; i < count; i++ {
+		for _, topic := range topics {
+			packet, err := codec.EncodeCommand(protocol.Command{Op: protocol.OpPublish, Topic: topic, Payload: []byte(message)})
+			logErr(err)
+			_, err = c.Write(packet)
+			logErr(err)
+		}
+		if interval > 0 {
+			time.Sleep(interval)
+		}
+	}
+	logging.Infof("published %d message(s) to %v", count, topics)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/panjf2000/gnet/v2/pkg/logging"
+)
+
+// touch records inbound traffic on s, pongs and any other packets alike prove the peer is alive.
+func (s *session) touch(now time.Time) {
+	atomic.StoreInt64(&s.lastActive, now.UnixNano())
+}
+
+// keepalive pings every connection that has been idle for a ping interval
+// and closes those that have stayed silent past the ping timeout, it runs on the ticker goroutine.
+func (sl *simpleListener) keepalive(now time.Time) {
+	interval, timeout := sl.srv.pingInterval, sl.srv.pingTimeout
+	ping := sl.srv.pingPacket
+	sl.sessions.Range(func(key, value interface{}) bool {
+		s := value.(*session)
+		idle := now.Sub(time.Unix(0, atomic.LoadInt64(&s.lastActive)))
+		switch {
+		case idle >= timeout:
+			logging.Warnf("connection=%s has been silent for %v, closing it", s.addr, idle)
+			// Closing is asynchronous, the next ticks are done with the session already.
+			sl.sessions.Delete(key)
+			_ = s.c.Close(nil)
+		case idle >= interval:
+			_ = s.c.AsyncWrite(ping, nil)
+		}
+		return true
+	})
+}
+
+// tick is the delay between two OnTick calls, fine enough for both stats and keepalive.
+func (s *simpleServer) tick() time.Duration {
+	delay := s.stats
+	if half := s.pingInterval / 2; half > 0 && (delay <= 0 || half < delay) {
+		delay = half
+	}
+	return delay
+}
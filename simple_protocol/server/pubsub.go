@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/panjf2000/gnet/v2"
+	"github.com/panjf2000/gnet/v2/pkg/logging"
+
+	"github.com/gnet-io/gnet-examples/simple_protocol/protocol"
+)
+
+// broker fans out published packets to the subscribers of a topic, which may live on any event-loop
+// of any listener, so deliveries always go through AsyncWrite.
+type broker struct {
+	mu          sync.RWMutex
+	topics      map[string]map[*session]struct{}
+	maxQueue    int32
+	maxOutbound int
+	published   int64
+	delivered   int64
+	evicted     int64
+}
+
+func newBroker(maxQueue, maxOutbound int) *broker {
+	return &broker{
+		topics:      make(map[string]map[*session]struct{}),
+		maxQueue:    int32(maxQueue),
+		maxOutbound: maxOutbound,
+	}
+}
+
+// subscribe, unsubscribe and unsubscribeAll are called on the event-loop owning s.
+func (b *broker) subscribe(s *session, topic string) {
+	if _, ok := s.topics[topic]; ok {
+		return
+	}
+	if s.topics == nil {
+		s.topics = make(map[string]struct{})
+	}
+	s.topics[topic] = struct{}{}
+	b.mu.Lock()
+	subs, ok := b.topics[topic]
+	if !ok {
+		subs = make(map[*session]struct{})
+		b.topics[topic] = subs
+	}
+	subs[s] = struct{}{}
+	b.mu.Unlock()
+}
+
+func (b *broker) unsubscribe(s *session, topic string) {
+	if _, ok := s.topics[topic]; !ok {
+		return
+	}
+	delete(s.topics, topic)
+	b.mu.Lock()
+	b.remove(s, topic)
+	b.mu.Unlock()
+}
+
+func (b *broker) unsubscribeAll(s *session) {
+	if len(s.topics) == 0 {
+		return
+	}
+	b.mu.Lock()
+	for topic := range s.topics {
+		b.remove(s, topic)
+	}
+	b.mu.Unlock()
+	s.topics = nil
+}
+
+func (b *broker) remove(s *session, topic string) {
+	subs := b.topics[topic]
+	delete(subs, s)
+	if len(subs) == 0 {
+		delete(b.topics, topic)
+	}
+}
+
+// publish queues the message for every subscriber of topic and evicts those that fall behind:
+// a subscriber is slow once it has more than maxQueue deliveries queued on its event-loop,
+// or more than maxOutbound bytes it hasn't been able to flush to its socket.
+func (b *broker) publish(topic string, payload []byte) (delivered int) {
+	var codec protocol.SimpleCodec
+	packet, err := codec.EncodeCommand(protocol.Command{Op: protocol.OpMessage, Topic: topic, Payload: payload})
+	if err != nil {
+		return
+	}
+	atomic.AddInt64(&b.published, 1)
+
+	b.mu.RLock()
+	subs := make([]*session, 0, len(b.topics[topic]))
+	for s := range b.topics[topic] {
+		subs = append(subs, s)
+	}
+	b.mu.RUnlock()
+
+	for _, s := range subs {
+		if atomic.LoadInt32(&s.evicted) == 1 {
+			continue
+		}
+		if b.maxQueue > 0 && atomic.AddInt32(&s.pending, 1) > b.maxQueue {
+			b.evict(s, "delivery queue is full")
+			continue
+		}
+		// The packet is only read by the event-loops, so it is shared by all subscribers.
+		err = s.c.AsyncWrite(packet, func(c gnet.Conn) error {
+			atomic.AddInt32(&s.pending, -1)
+			if b.maxOutbound > 0 && c.OutboundBuffered() > b.maxOutbound {
+				b.evict(s, "outbound buffer is full")
+			}
+			return nil
+		})
+		if err != nil {
+			atomic.AddInt32(&s.pending, -1)
+			continue
+		}
+		delivered++
+	}
+	atomic.AddInt64(&b.delivered, int64(delivered))
+	return
+}
+
+func (b *broker) evict(s *session, reason string) {
+	if !atomic.CompareAndSwapInt32(&s.evicted, 0, 1) {
+		return
+	}
+	atomic.AddInt64(&b.evicted, 1)
+	logging.Warnf("evicting slow subscriber %s: %s", s.addr, reason)
+	_ = s.c.Close(nil)
+}
+
+func (b *broker) statsString() string {
+	b.mu.RLock()
+	topics := len(b.topics)
+	b.mu.RUnlock()
+	return fmt.Sprintf("[topics=%d published=%d delivered=%d evicted=%d]", topics,
+		atomic.LoadInt64(&b.published), atomic.LoadInt64(&b.delivered), atomic.LoadInt64(&b.evicted))
+}
+
+// onCommands handles the inbound packets of a connection in publish/subscribe mode.
+func (sl *simpleListener) onCommands(c gnet.Conn, s *session) (action gnet.Action) {
+	b := sl.srv.broker
+	for {
+		data, err := s.codec.Decode(c)
+		if err == protocol.ErrIncompletePacket {
+			break
+		}
+		if err != nil {
+			logging.Errorf("invalid packet: %v", err)
+			return gnet.Close
+		}
+		sl.count(len(data))
+		cmd, err := s.codec.DecodeCommand(data)
+		if err != nil {
+			logging.Errorf("invalid command from %s: %v", c.RemoteAddr().String(), err)
+			return gnet.Close
+		}
+		switch cmd.Op {
+		case protocol.OpSubscribe:
+			b.subscribe(s, cmd.Topic)
+		case protocol.OpUnsubscribe:
+			b.unsubscribe(s, cmd.Topic)
+		case protocol.OpPublish:
+			b.publish(cmd.Topic, cmd.Payload)
+		default:
+			logging.Errorf("unexpected command %s from %s", cmd.Op, c.RemoteAddr().String())
+			return gnet.Close
+		}
+	}
+	return
+}
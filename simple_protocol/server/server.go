@@ -1,55 +1,132 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/panjf2000/gnet/v2"
 	"github.com/panjf2000/gnet/v2/pkg/logging"
 
+	"github.com/gnet-io/gnet-examples/config"
 	"github.com/gnet-io/gnet-examples/simple_protocol/protocol"
 )
 
+// simpleServer serves the same handler on every listener and shuts all of them down together.
 type simpleServer struct {
+	listeners    []*simpleListener
+	cfg          *config.Server
+	stats        time.Duration
+	maxDatagram  int
+	broker       *broker
+	pingInterval time.Duration
+	pingTimeout  time.Duration
+	pingPacket   []byte
+	connected    int32
+	disconnected int32
+	shutdownOnce sync.Once
+	closing      int32
+}
+
+// session is the context of a stream connection.
+type session struct {
+	c     gnet.Conn
+	codec protocol.SimpleCodec
+	// addr is the remote address of c, for the goroutines other than its event-loop to log:
+	// they may only call AsyncWrite and Close on c, which gnet releases once closed.
+	addr string
+
+	// topics is only accessed on the event-loop of c,
+	// pending and evicted are updated by publishers on other event-loops,
+	// lastActive is read by the ticker.
+	topics     map[string]struct{}
+	pending    int32
+	evicted    int32
+	lastActive int64
+}
+
+// simpleListener is the gnet.EventHandler of one engine, it keeps its own stats.
+type simpleListener struct {
 	gnet.BuiltinEventEngine
+	srv          *simpleServer
 	eng          gnet.Engine
-	network      string
-	addr         string
-	multicore    bool
+	protoAddr    string
+	datagram     bool
+	done         chan struct{}
+	sessions     sync.Map
+	nextStats    time.Time
 	connected    int32
-	disconnected int32
+	accepted     int64
+	disconnected int64
+	packets      int64
+	bytes        int64
+	dropped      int64
 }
 
-func (s *simpleServer) OnBoot(eng gnet.Engine) (action gnet.Action) {
-	logging.Infof("running server on %s with multi-core=%t",
-		fmt.Sprintf("%s://%s", s.network, s.addr), s.multicore)
-	s.eng = eng
+func (sl *simpleListener) OnBoot(eng gnet.Engine) (action gnet.Action) {
+	logging.Infof("running server on %s with multi-core=%t", sl.protoAddr, sl.srv.cfg.Multicore)
+	sl.eng = eng
+	if atomic.LoadInt32(&sl.srv.closing) == 1 {
+		action = gnet.Shutdown
+	}
 	return
 }
 
-func (s *simpleServer) OnOpen(c gnet.Conn) (out []byte, action gnet.Action) {
-	c.SetContext(new(protocol.SimpleCodec))
-	atomic.AddInt32(&s.connected, 1)
+func (sl *simpleListener) OnShutdown(_ gnet.Engine) {
+	logging.Infof("server on %s is shut down, %s", sl.protoAddr, sl.statsString())
+}
+
+func (sl *simpleListener) OnOpen(c gnet.Conn) (out []byte, action gnet.Action) {
+	s := &session{c: c, addr: c.RemoteAddr().String()}
+	s.touch(time.Now())
+	c.SetContext(s)
+	sl.sessions.Store(c, s)
+	atomic.AddInt32(&sl.connected, 1)
+	atomic.AddInt64(&sl.accepted, 1)
+	atomic.AddInt32(&sl.srv.connected, 1)
 	out = []byte("sweetness\r\n")
 	return
 }
 
-func (s *simpleServer) OnClose(c gnet.Conn, err error) (action gnet.Action) {
+func (sl *simpleListener) OnClose(c gnet.Conn, err error) (action gnet.Action) {
+	// The ticker mustn't get hold of the session once gnet releases c.
+	sl.sessions.Delete(c)
 	if err != nil {
 		logging.Infof("error occurred on connection=%s, %v\n", c.RemoteAddr().String(), err)
 	}
-	disconnected := atomic.AddInt32(&s.disconnected, 1)
-	connected := atomic.AddInt32(&s.connected, -1)
-	if connected == 0 {
+	s := c.Context().(*session)
+	if sl.srv.broker != nil {
+		sl.srv.broker.unsubscribeAll(s)
+	}
+	atomic.AddInt32(&sl.connected, -1)
+	atomic.AddInt64(&sl.disconnected, 1)
+	disconnected := atomic.AddInt32(&sl.srv.disconnected, 1)
+	connected := atomic.AddInt32(&sl.srv.connected, -1)
+	// A publish/subscribe server outlives its clients, subscribers come and go.
+	if connected == 0 && sl.srv.broker == nil {
 		logging.Infof("all %d connections are closed, shut it down", disconnected)
-		action = gnet.Shutdown
+		sl.srv.shutdown()
 	}
 	return
 }
 
-func (s *simpleServer) OnTraffic(c gnet.Conn) (action gnet.Action) {
-	codec := c.Context().(*protocol.SimpleCodec)
+func (sl *simpleListener) OnTraffic(c gnet.Conn) (action gnet.Action) {
+	if sl.datagram {
+		return sl.onDatagram(c)
+	}
+	s := c.Context().(*session)
+	s.touch(time.Now())
+	if sl.srv.broker != nil {
+		return sl.onCommands(c, s)
+	}
+	codec := &s.codec
 	var packets [][]byte
 	for {
 		data, err := codec.Decode(c)
@@ -60,6 +137,7 @@ func (s *simpleServer) OnTraffic(c gnet.Conn) (action gnet.Action) {
 			logging.Errorf("invalid packet: %v", err)
 			return gnet.Close
 		}
+		sl.count(len(data))
 		packet, _ := codec.Encode(data)
 		packets = append(packets, packet)
 	}
@@ -71,19 +149,135 @@ func (s *simpleServer) OnTraffic(c gnet.Conn) (action gnet.Action) {
 	return
 }
 
+func (sl *simpleListener) OnTick() (delay time.Duration, action gnet.Action) {
+	now := time.Now()
+	if sl.srv.stats > 0 && !now.Before(sl.nextStats) {
+		sl.nextStats = now.Add(sl.srv.stats)
+		logging.Infof("stats of %s: %s", sl.protoAddr, sl.statsString())
+		// The broker is shared by all listeners, let only the first one report it.
+		if b := sl.srv.broker; b != nil && sl == sl.srv.listeners[0] {
+			logging.Infof("stats of broker: %s", b.statsString())
+		}
+	}
+	if sl.srv.pingInterval > 0 && !sl.datagram {
+		sl.keepalive(now)
+	}
+	return sl.srv.tick(), gnet.None
+}
+
+func (sl *simpleListener) count(bodyLen int) {
+	atomic.AddInt64(&sl.packets, 1)
+	atomic.AddInt64(&sl.bytes, int64(bodyLen))
+}
+
+func (sl *simpleListener) statsString() string {
+	if sl.datagram {
+		return fmt.Sprintf("[packets=%d bytes=%d dropped=%d]",
+			atomic.LoadInt64(&sl.packets), atomic.LoadInt64(&sl.bytes), atomic.LoadInt64(&sl.dropped))
+	}
+	return fmt.Sprintf("[connected=%d accepted=%d disconnected=%d packets=%d bytes=%d]",
+		atomic.LoadInt32(&sl.connected), atomic.LoadInt64(&sl.accepted), atomic.LoadInt64(&sl.disconnected),
+		atomic.LoadInt64(&sl.packets), atomic.LoadInt64(&sl.bytes))
+}
+
+// shutdown stops every engine exactly once, it must not block the calling event-loop.
+func (s *simpleServer) shutdown() {
+	s.shutdownOnce.Do(func() {
+		atomic.StoreInt32(&s.closing, 1)
+		for _, sl := range s.listeners {
+			go sl.stop()
+		}
+	})
+}
+
+// stop keeps asking gnet to stop the engine until it exits, an engine that is still booting
+// isn't registered yet and gnet.Stop reports it as already in shutdown.
+func (sl *simpleListener) stop() {
+	for {
+		err := gnet.Stop(context.Background(), sl.protoAddr)
+		if err == nil {
+			return
+		}
+		select {
+		case <-sl.done:
+			return
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+func (s *simpleServer) run() {
+	var wg sync.WaitGroup
+	for _, sl := range s.listeners {
+		wg.Add(1)
+		go func(sl *simpleListener) {
+			defer wg.Done()
+			defer close(sl.done)
+			config.RemoveStaleSocket(sl.protoAddr)
+			err := gnet.Run(sl, sl.protoAddr, s.cfg.Options(gnet.WithTicker(s.tick() > 0))...)
+			logging.Infof("server on %s exits with error: %v", sl.protoAddr, err)
+			// A listener that fails to start must not leave the others running.
+			s.shutdown()
+		}(sl)
+	}
+	wg.Wait()
+}
+
 func main() {
 	var port int
-	var multicore bool
+	var cfg config.Server
+	var stats time.Duration
+	var maxDatagram int
+	var pubsub bool
+	var maxQueue, maxOutbound int
+	var pingInterval, pingTimeout time.Duration
 
-	// Example command: go run server.go --port 9000 --multicore=true
-	flag.IntVar(&port, "port", 9000, "--port 9000")
-	flag.BoolVar(&multicore, "multicore", false, "--multicore=true")
+	// Example command: go run . --listen tcp://:9000 --listen unix://simple.sock --listen udp://:9000 --multicore=true
+	// or: GNET_LISTEN=tcp://:9000,udp://:9000 go run . --config simple.yaml
+	cfg.Flags(flag.CommandLine)
+	flag.IntVar(&port, "port", 9000, "--port 9000, used when no --listen is given")
+	flag.DurationVar(&stats, "stats", 0, "--stats 10s, interval of logging per-listener stats")
+	flag.IntVar(&maxDatagram, "max_datagram", protocol.DefaultMaxDatagramSize, "--max_datagram 1472, max size of a UDP datagram, 0 for the default")
+	flag.BoolVar(&pubsub, "pubsub", false, "--pubsub=true, serve SUBSCRIBE/UNSUBSCRIBE/PUBLISH commands instead of echoing")
+	flag.IntVar(&maxQueue, "max_queue", 1024, "--max_queue 1024, max queued deliveries per subscriber before eviction")
+	flag.IntVar(&maxOutbound, "max_outbound", 4<<20, "--max_outbound 4194304, max unflushed bytes per subscriber before eviction")
+	flag.DurationVar(&pingInterval, "ping_interval", 0, "--ping_interval 10s, ping connections idle for this long, 0 disables keepalive")
+	flag.DurationVar(&pingTimeout, "ping_timeout", 0, "--ping_timeout 30s, close connections silent for this long, defaults to 3 ping intervals")
 	flag.Parse()
-	ss := &simpleServer{
-		network:   "tcp",
-		addr:      fmt.Sprintf(":%d", port),
-		multicore: multicore,
+	if err := cfg.Load(flag.CommandLine); err != nil {
+		logging.Fatalf("%v", err)
 	}
-	err := gnet.Run(ss, ss.network+"://"+ss.addr, gnet.WithMulticore(multicore))
-	logging.Infof("server exits with error: %v", err)
+	if len(cfg.Listen) == 0 {
+		cfg.Listen = []string{fmt.Sprintf("tcp://:%d", port)}
+	}
+
+	ss := &simpleServer{cfg: &cfg, stats: stats, maxDatagram: maxDatagram}
+	if pingInterval > 0 {
+		if pingTimeout <= 0 {
+			pingTimeout = 3 * pingInterval
+		}
+		ss.pingInterval, ss.pingTimeout = pingInterval, pingTimeout
+		ss.pingPacket = protocol.SimpleCodec{}.EncodePing(nil)
+	}
+	if pubsub {
+		ss.broker = newBroker(maxQueue, maxOutbound)
+	}
+	for _, addr := range cfg.Listen {
+		ss.listeners = append(ss.listeners, &simpleListener{
+			srv:       ss,
+			protoAddr: addr,
+			datagram:  strings.HasPrefix(addr, "udp"),
+			done:      make(chan struct{}),
+		})
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		logging.Infof("received signal, shutting down all listeners")
+		ss.shutdown()
+	}()
+
+	ss.run()
 }
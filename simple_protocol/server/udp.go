@@ -0,0 +1,37 @@
+package main
+
+import (
+	"sync/atomic"
+
+	"github.com/panjf2000/gnet/v2"
+	"github.com/panjf2000/gnet/v2/pkg/logging"
+
+	"github.com/gnet-io/gnet-examples/simple_protocol/protocol"
+)
+
+// onDatagram echoes every packet carried by a single UDP datagram.
+// UDP connections have neither OnOpen nor a context and the datagram is all the data there is,
+// so a datagram that is oversized or ends with a partial packet is dropped as a whole.
+func (sl *simpleListener) onDatagram(c gnet.Conn) (action gnet.Action) {
+	var codec protocol.SimpleCodec
+	buf, _ := c.Next(-1)
+	bodies, err := codec.UnpackDatagram(buf, sl.srv.maxDatagram)
+	if err != nil {
+		atomic.AddInt64(&sl.dropped, 1)
+		logging.Errorf("invalid datagram from %s: %v", c.RemoteAddr().String(), err)
+		return
+	}
+	for _, body := range bodies {
+		sl.count(len(body))
+	}
+	datagrams, err := codec.PackDatagrams(bodies, sl.srv.maxDatagram)
+	if err != nil {
+		atomic.AddInt64(&sl.dropped, 1)
+		logging.Errorf("failed to pack reply to %s: %v", c.RemoteAddr().String(), err)
+		return
+	}
+	for _, datagram := range datagrams {
+		_, _ = c.Write(datagram)
+	}
+	return
+}
@@ -10,6 +10,8 @@ import (
 	"github.com/gobwas/ws/wsutil"
 	"github.com/panjf2000/gnet/v2"
 	"github.com/panjf2000/gnet/v2/pkg/logging"
+
+	"github.com/gnet-io/gnet-examples/config"
 )
 
 type wsServer struct {
@@ -80,21 +82,31 @@ func (wss *wsServer) OnTraffic(c gnet.Conn) (action gnet.Action) {
 }
 
 func (wss *wsServer) OnTick() (delay time.Duration, action gnet.Action) {
-	logging.Infof("[connected-count=%v]", atomic.LoadInt64(&wss.connected))
+	logging.Infof("[connected-count=%v] on %s", atomic.LoadInt64(&wss.connected), wss.addr)
 	return 3 * time.Second, gnet.None
 }
 
 func main() {
 	var port int
-	var multicore bool
+	cfg := config.Server{Multicore: true, ReusePort: true}
 
 	// Example command: go run main.go --port 8080 --multicore=true
-	flag.IntVar(&port, "port", 9080, "server port")
-	flag.BoolVar(&multicore, "multicore", true, "multicore")
+	// or: go run main.go --listen tcp://0.0.0.0:8080 --listen tcp://[::]:8080 --load_balancing least_connections
+	cfg.Flags(flag.CommandLine)
+	flag.IntVar(&port, "port", 9080, "server port, listened on 127.0.0.1 when no --listen is given")
 	flag.Parse()
-
-	wss := &wsServer{addr: fmt.Sprintf("tcp://127.0.0.1:%d", port), multicore: multicore}
+	if err := cfg.Load(flag.CommandLine); err != nil {
+		log.Fatal(err)
+	}
+	if len(cfg.Listen) == 0 {
+		cfg.Listen = []string{fmt.Sprintf("tcp://127.0.0.1:%d", port)}
+	}
+	if err := cfg.Only("tcp", "unix"); err != nil {
+		log.Fatal(err)
+	}
 
 	// Start serving!
-	log.Println("server exits:", gnet.Run(wss, wss.addr, gnet.WithMulticore(multicore), gnet.WithReusePort(true), gnet.WithTicker(true)))
+	log.Println("server exits:", cfg.Run(func(url string) gnet.EventHandler {
+		return &wsServer{addr: url, multicore: cfg.Multicore}
+	}, gnet.WithTicker(true)))
 }